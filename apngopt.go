@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"sync"
+
+	"github.com/kettek/apng"
+)
+
+// apngCandidate is one way of storing a frame relative to the one before it.
+type apngCandidate struct {
+	frame apngFrame
+	delay float64
+	// prevDisposeOp is the dispose op the previous frame must use for this
+	// candidate to be correct.
+	prevDisposeOp byte
+}
+
+// diffBounds returns the bounding rectangle of the pixels that differ between
+// a and b, which must share the same bounds.
+func diffBounds(a, b *image.RGBA) image.Rectangle {
+	r := a.Rect
+	minX, minY, maxX, maxY := r.Max.X, r.Max.Y, r.Min.X, r.Min.Y
+	w := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		o := a.PixOffset(r.Min.X, y)
+		rowA, rowB := a.Pix[o:o+w], b.Pix[o:o+w]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		if y < minY {
+			minY = y
+		}
+		maxY = y + 1
+		for i := 0; i < w; i += 4 {
+			if !bytes.Equal(rowA[i:i+4], rowB[i:i+4]) {
+				if x := r.Min.X + i/4; x < minX {
+					minX = x
+				}
+				break
+			}
+		}
+		for i := w - 4; i >= 0; i -= 4 {
+			if !bytes.Equal(rowA[i:i+4], rowB[i:i+4]) {
+				if x := r.Min.X + i/4 + 1; x > maxX {
+					maxX = x
+				}
+				break
+			}
+		}
+	}
+	if minY > maxY {
+		return image.Rectangle{}
+	}
+	return image.Rect(minX, minY, maxX, maxY)
+}
+
+// overlay returns the r area of cur with every pixel that matches base made
+// fully transparent, for use with BLEND_OP_OVER. It returns nil if a changed
+// pixel is translucent, as blending it would not reproduce cur.
+func overlay(base, cur *image.RGBA, r image.Rectangle) *image.RGBA {
+	out := image.NewRGBA(r)
+	w := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		o := cur.PixOffset(r.Min.X, y)
+		d := out.PixOffset(r.Min.X, y)
+		for i := 0; i < w; i += 4 {
+			if bytes.Equal(cur.Pix[o+i:o+i+4], base.Pix[o+i:o+i+4]) {
+				continue
+			}
+			if cur.Pix[o+i+3] != 255 {
+				return nil
+			}
+			copy(out.Pix[d+i:d+i+4], cur.Pix[o+i:o+i+4])
+		}
+	}
+	return out
+}
+
+// optimizeAPNGFrame tries storing cur as the changed area over prev, with the
+// previous frame either left in place or cleared, and blended either as a
+// replacement or over the canvas. It returns the smallest candidate along
+// with the compressed size of the full frame, or nil if cur matches prev.
+func optimizeAPNGFrame(prev, cur *image.RGBA, prevBounds image.Rectangle) (*apngCandidate, int, error) {
+	keepBounds := diffBounds(prev, cur)
+	if keepBounds.Empty() {
+		return nil, 0, nil
+	}
+
+	cleared := image.NewRGBA(prev.Rect)
+	copy(cleared.Pix, prev.Pix)
+	for y := prevBounds.Min.Y; y < prevBounds.Max.Y; y++ {
+		o := cleared.PixOffset(prevBounds.Min.X, y)
+		for i := o; i < o+prevBounds.Dx()*4; i++ {
+			cleared.Pix[i] = 0
+		}
+	}
+	clearBounds := diffBounds(cleared, cur)
+
+	type attempt struct {
+		img     *image.RGBA
+		bounds  image.Rectangle
+		dispose byte
+		blend   byte
+		data    []byte
+		err     error
+	}
+	attempts := []*attempt{
+		{img: cur, bounds: cur.Rect, dispose: apng.DISPOSE_OP_NONE, blend: apng.BLEND_OP_SOURCE},
+		{img: cur, bounds: keepBounds, dispose: apng.DISPOSE_OP_NONE, blend: apng.BLEND_OP_SOURCE},
+	}
+	if o := overlay(prev, cur, keepBounds); o != nil {
+		attempts = append(attempts, &attempt{img: o, bounds: keepBounds, dispose: apng.DISPOSE_OP_NONE, blend: apng.BLEND_OP_OVER})
+	}
+	if !clearBounds.Empty() && clearBounds != keepBounds {
+		attempts = append(attempts, &attempt{img: cur, bounds: clearBounds, dispose: apng.DISPOSE_OP_BACKGROUND, blend: apng.BLEND_OP_SOURCE})
+		if o := overlay(cleared, cur, clearBounds); o != nil {
+			attempts = append(attempts, &attempt{img: o, bounds: clearBounds, dispose: apng.DISPOSE_OP_BACKGROUND, blend: apng.BLEND_OP_OVER})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, at := range attempts {
+		wg.Add(1)
+		go func(at *attempt) {
+			defer wg.Done()
+			at.data, at.err = compressFrame(at.img, at.bounds)
+		}(at)
+	}
+	wg.Wait()
+
+	// The first attempt is the unoptimized full frame, kept for comparison.
+	var best *attempt
+	for _, at := range attempts {
+		if at.err != nil {
+			return nil, 0, at.err
+		}
+		if best == nil || len(at.data) < len(best.data) {
+			best = at
+		}
+	}
+	return &apngCandidate{
+		frame: apngFrame{
+			data:      best.data,
+			bounds:    best.bounds,
+			disposeOp: apng.DISPOSE_OP_NONE,
+			blendOp:   best.blend,
+		},
+		prevDisposeOp: best.dispose,
+	}, len(attempts[0].data), nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+
+	"github.com/kettek/apng"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// apngFrame is a single compressed frame ready to be written.
+type apngFrame struct {
+	data             []byte
+	bounds           image.Rectangle
+	delayNumerator   uint16
+	delayDenominator uint16
+	disposeOp        byte
+	blendOp          byte
+}
+
+// apngWriter writes an 8-bit RGBA animated PNG one frame at a time so that
+// only the frame currently being written needs to be held in memory.
+type apngWriter struct {
+	w      io.Writer
+	width  int
+	height int
+	frames int
+	loops  uint
+	seq    uint32
+	count  int
+	// actlOffset is where the acTL chunk was written, so the frame count can
+	// be corrected on close if the writer is seekable.
+	actlOffset int64
+	tmp        [26]byte
+}
+
+func newAPNGWriter(w io.Writer, width, height, frames int, loops uint) (*apngWriter, error) {
+	aw := &apngWriter{w: w, width: width, height: height, frames: frames, loops: loops, actlOffset: -1}
+	if _, err := io.WriteString(w, pngHeader); err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint32(aw.tmp[0:4], uint32(width))
+	binary.BigEndian.PutUint32(aw.tmp[4:8], uint32(height))
+	aw.tmp[8] = 8  // bit depth
+	aw.tmp[9] = 6  // truecolor with alpha
+	aw.tmp[10] = 0 // compression method
+	aw.tmp[11] = 0 // filter method
+	aw.tmp[12] = 0 // interlace method
+	if err := aw.writeChunk("IHDR", aw.tmp[:13]); err != nil {
+		return nil, err
+	}
+
+	if s, ok := w.(io.Seeker); ok {
+		if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+			aw.actlOffset = off
+		}
+	}
+	if err := aw.writeacTL(uint32(frames), uint32(loops)); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (aw *apngWriter) writeChunk(name string, b []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(b)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(b)
+	if _, err := aw.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(b); err != nil {
+		return err
+	}
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+	_, err := aw.w.Write(footer[:])
+	return err
+}
+
+func (aw *apngWriter) writeacTL(frames, loops uint32) error {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[0:4], frames)
+	binary.BigEndian.PutUint32(b[4:8], loops)
+	return aw.writeChunk("acTL", b[:])
+}
+
+// writeFrame writes the control chunk and image data for f. The first frame
+// is stored as IDAT and so doubles as the default image.
+func (aw *apngWriter) writeFrame(f apngFrame) error {
+	if aw.count == 0 && f.bounds != image.Rect(0, 0, aw.width, aw.height) {
+		return fmt.Errorf("first frame must cover the full %dx%d canvas", aw.width, aw.height)
+	}
+	binary.BigEndian.PutUint32(aw.tmp[0:4], aw.seq)
+	aw.seq++
+	binary.BigEndian.PutUint32(aw.tmp[4:8], uint32(f.bounds.Dx()))
+	binary.BigEndian.PutUint32(aw.tmp[8:12], uint32(f.bounds.Dy()))
+	binary.BigEndian.PutUint32(aw.tmp[12:16], uint32(f.bounds.Min.X))
+	binary.BigEndian.PutUint32(aw.tmp[16:20], uint32(f.bounds.Min.Y))
+	binary.BigEndian.PutUint16(aw.tmp[20:22], f.delayNumerator)
+	binary.BigEndian.PutUint16(aw.tmp[22:24], f.delayDenominator)
+	aw.tmp[24] = f.disposeOp
+	aw.tmp[25] = f.blendOp
+	if err := aw.writeChunk("fcTL", aw.tmp[:26]); err != nil {
+		return err
+	}
+
+	const maxChunk = 1 << 20
+	data := f.data
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxChunk {
+			n = maxChunk
+		}
+		if aw.count == 0 {
+			if err := aw.writeChunk("IDAT", data[:n]); err != nil {
+				return err
+			}
+		} else {
+			b := make([]byte, 4+n)
+			binary.BigEndian.PutUint32(b[:4], aw.seq)
+			aw.seq++
+			copy(b[4:], data[:n])
+			if err := aw.writeChunk("fdAT", b); err != nil {
+				return err
+			}
+		}
+		data = data[n:]
+	}
+	aw.count++
+	return nil
+}
+
+// close ends the stream, rewriting the acTL frame count if fewer or more
+// frames were written than announced.
+func (aw *apngWriter) close() error {
+	if err := aw.writeChunk("IEND", nil); err != nil {
+		return err
+	}
+	if aw.count == aw.frames {
+		return nil
+	}
+	s, ok := aw.w.(io.WriteSeeker)
+	if !ok || aw.actlOffset < 0 {
+		return fmt.Errorf("wrote %d frames, expected %d", aw.count, aw.frames)
+	}
+	end, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Seek(aw.actlOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := aw.writeacTL(uint32(aw.count), uint32(aw.loops)); err != nil {
+		return err
+	}
+	_, err = s.Seek(end, io.SeekStart)
+	return err
+}
+
+func abs8(d uint8) int {
+	if d < 128 {
+		return int(d)
+	}
+	return 256 - int(d)
+}
+
+// compressFrame filters and deflates the r area of img as non-premultiplied
+// RGBA scanlines.
+func compressFrame(img *image.RGBA, r image.Rectangle) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	w := r.Dx() * 4
+	prev := make([]byte, w)
+	raw := make([]byte, w)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, w+1)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		o := img.PixOffset(r.Min.X, y)
+		copy(raw, img.Pix[o:o+w])
+		for i := 0; i < w; i += 4 {
+			if a := raw[i+3]; a != 0 && a != 255 {
+				raw[i] = uint8(uint32(raw[i]) * 255 / uint32(a))
+				raw[i+1] = uint8(uint32(raw[i+1]) * 255 / uint32(a))
+				raw[i+2] = uint8(uint32(raw[i+2]) * 255 / uint32(a))
+			}
+		}
+
+		// Choose the filter with the smallest sum of absolute differences.
+		best, bestSum := 0, math.MaxInt
+		for ft := 0; ft < 5; ft++ {
+			cdat := filtered[ft][1:]
+			sum := 0
+			for i := 0; i < w; i++ {
+				var left, up, upLeft uint8
+				if i >= 4 {
+					left = raw[i-4]
+					upLeft = prev[i-4]
+				}
+				up = prev[i]
+				switch ft {
+				case 0:
+					cdat[i] = raw[i]
+				case 1:
+					cdat[i] = raw[i] - left
+				case 2:
+					cdat[i] = raw[i] - up
+				case 3:
+					cdat[i] = raw[i] - uint8((int(left)+int(up))/2)
+				case 4:
+					cdat[i] = raw[i] - paeth(left, up, upLeft)
+				}
+				sum += abs8(cdat[i])
+			}
+			if sum < bestSum {
+				best, bestSum = ft, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev, raw = raw, prev
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := p-int(a), p-int(b), p-int(c)
+	if pa < 0 {
+		pa = -pa
+	}
+	if pb < 0 {
+		pb = -pb
+	}
+	if pc < 0 {
+		pc = -pc
+	}
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// apngDelay converts seconds into the smallest denominator that can hold it.
+func apngDelay(seconds float64) (uint16, uint16) {
+	for _, den := range []float64{1000, 100, 10, 1} {
+		if n := math.Round(seconds * den); n <= math.MaxUint16 {
+			return uint16(n), uint16(den)
+		}
+	}
+	return math.MaxUint16, 1
+}
+
+type apngStats struct {
+	frames int
+	// size is the number of compressed image bytes written.
+	size int64
+	// fullSize is the number of bytes full-canvas frames would have taken.
+	fullSize int64
+}
+
+// apngSink compresses frames into an APNG as they arrive.
+type apngSink struct {
+	aw       *apngWriter
+	canvas   image.Rectangle
+	optimize bool
+	stats    apngStats
+
+	// The pending frame is held back until the next one decides its dispose op.
+	pending      *apngCandidate
+	prev         *image.RGBA
+	prevFullSize int
+}
+
+func newAPNGSink(w io.Writer, canvas image.Rectangle, frames int, optimize bool, plays uint) (*apngSink, error) {
+	aw, err := newAPNGWriter(w, canvas.Dx(), canvas.Dy(), frames, plays)
+	if err != nil {
+		return nil, err
+	}
+	return &apngSink{aw: aw, canvas: canvas, optimize: optimize}, nil
+}
+
+func (s *apngSink) write(c *apngCandidate) error {
+	c.frame.delayNumerator, c.frame.delayDenominator = apngDelay(c.delay)
+	s.stats.frames++
+	s.stats.size += int64(len(c.frame.data))
+	return s.aw.writeFrame(c.frame)
+}
+
+func (s *apngSink) add(cur *image.RGBA, delay float64) error {
+	if s.prev == nil || !s.optimize {
+		data, err := compressFrame(cur, s.canvas)
+		if err != nil {
+			return err
+		}
+		s.stats.fullSize += int64(len(data))
+		if s.pending != nil {
+			if err := s.write(s.pending); err != nil {
+				return err
+			}
+		}
+		s.pending = &apngCandidate{
+			frame: apngFrame{
+				data:      data,
+				bounds:    s.canvas,
+				disposeOp: apng.DISPOSE_OP_NONE,
+				blendOp:   apng.BLEND_OP_SOURCE,
+			},
+			delay: delay,
+		}
+		s.prev, s.prevFullSize = cur, len(data)
+		return nil
+	}
+
+	c, fullSize, err := optimizeAPNGFrame(s.prev, cur, s.pending.frame.bounds)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		// Identical to the previous frame, so just extend its delay.
+		s.stats.fullSize += int64(s.prevFullSize)
+		s.pending.delay += delay
+		return nil
+	}
+	s.stats.fullSize += int64(fullSize)
+	s.pending.frame.disposeOp = c.prevDisposeOp
+	if err := s.write(s.pending); err != nil {
+		return err
+	}
+	c.delay = delay
+	s.pending = c
+	s.prev, s.prevFullSize = cur, fullSize
+	return nil
+}
+
+func (s *apngSink) close() error {
+	if s.pending == nil {
+		return fmt.Errorf("no frames were decoded")
+	}
+	if err := s.write(s.pending); err != nil {
+		return err
+	}
+	return s.aw.close()
+}
+
+// String summarizes the written file and what optimization saved.
+func (st apngStats) String() string {
+	if st.fullSize > st.size {
+		saved := st.fullSize - st.size
+		return fmt.Sprintf("%d frames, %.2f MB (saved %.2f MB, %.0f%%)", st.frames, float64(st.size)/1024/1024, float64(saved)/1024/1024, float64(saved)*100/float64(st.fullSize))
+	}
+	return fmt.Sprintf("%d frames, %.2f MB", st.frames, float64(st.size)/1024/1024)
+}
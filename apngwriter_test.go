@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kettek/apng"
+)
+
+// compositeAPNG renders the frames of a in turn, applying their blend and
+// dispose ops, and returns the canvas shown for each.
+func compositeAPNG(a apng.APNG, canvas image.Rectangle) []*image.RGBA {
+	cur := image.NewRGBA(canvas)
+	var shown []*image.RGBA
+	for _, f := range a.Frames {
+		if f.IsDefault {
+			continue
+		}
+		prev := image.NewRGBA(canvas)
+		copy(prev.Pix, cur.Pix)
+		r := f.Image.Bounds().Sub(f.Image.Bounds().Min).Add(image.Pt(f.XOffset, f.YOffset))
+		op := draw.Src
+		if f.BlendOp == apng.BLEND_OP_OVER {
+			op = draw.Over
+		}
+		draw.Draw(cur, r, f.Image, f.Image.Bounds().Min, op)
+		c := image.NewRGBA(canvas)
+		copy(c.Pix, cur.Pix)
+		shown = append(shown, c)
+		switch f.DisposeOp {
+		case apng.DISPOSE_OP_BACKGROUND:
+			draw.Draw(cur, r, image.Transparent, image.Point{}, draw.Src)
+		case apng.DISPOSE_OP_PREVIOUS:
+			cur = prev
+		}
+	}
+	return shown
+}
+
+func TestAPNGSinkRoundTrip(t *testing.T) {
+	frames := testFrames(5, image.Pt(40, 24))
+	// The third frame repeats the second, so optimizing merges them.
+	frames[2] = frames[1]
+	delays := []float64{0.1, 0.2, 0.1, 1.0 / 3, 0.5}
+
+	for _, optimize := range []bool{false, true} {
+		// Merged frames leave the announced count to be rewritten, which
+		// needs a file to seek back in.
+		p := filepath.Join(t.TempDir(), "out.png")
+		out, err := os.Create(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		canvas := frames[0].Rect
+		s, err := newAPNGSink(out, canvas, len(frames), optimize, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range frames {
+			if err := s.add(m, delays[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := apng.DecodeAll(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("optimize %v: %v", optimize, err)
+		}
+		if a.LoopCount != 3 {
+			t.Errorf("optimize %v: loops %d, want 3", optimize, a.LoopCount)
+		}
+		wantFrames, wantDelays := frames, delays
+		if optimize {
+			wantFrames = []*image.RGBA{frames[0], frames[1], frames[3], frames[4]}
+			wantDelays = []float64{0.1, 0.3, 1.0 / 3, 0.5}
+		}
+		shown := compositeAPNG(a, canvas)
+		if len(shown) != len(wantFrames) || s.stats.frames != len(wantFrames) {
+			t.Fatalf("optimize %v: %d frames decoded, %d counted, want %d", optimize, len(shown), s.stats.frames, len(wantFrames))
+		}
+		var i int
+		for _, f := range a.Frames {
+			if f.IsDefault {
+				continue
+			}
+			if !bytes.Equal(shown[i].Pix, wantFrames[i].Pix) {
+				t.Errorf("optimize %v: frame %d differs", optimize, i)
+			}
+			if d := f.GetDelay(); math.Abs(d-wantDelays[i]) > 1e-3 {
+				t.Errorf("optimize %v: frame %d shown %v, want %v", optimize, i, d, wantDelays[i])
+			}
+			i++
+		}
+	}
+}
+
+func TestAPNGSinkNeedsSeekToMerge(t *testing.T) {
+	frames := testFrames(2, image.Pt(16, 16))
+	var b bytes.Buffer
+	s, err := newAPNGSink(&b, frames[0].Rect, 3, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*image.RGBA{frames[0], frames[0], frames[1]} {
+		if err := s.add(m, 0.1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err == nil {
+		t.Error("wrong frame count left in an unseekable stream")
+	}
+}
+
+func TestAPNGDelay(t *testing.T) {
+	for _, tt := range []struct {
+		seconds  float64
+		num, den uint16
+	}{
+		{0.1, 100, 1000},
+		{1.0 / 3, 333, 1000},
+		{70, 7000, 100},
+		{1000, 10000, 10},
+		{1e6, math.MaxUint16, 1},
+	} {
+		if num, den := apngDelay(tt.seconds); num != tt.num || den != tt.den {
+			t.Errorf("%v: got %d/%d, want %d/%d", tt.seconds, num, den, tt.num, tt.den)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// backgroundAudio is a sound track muxed into the video outputs of the ffmpeg
+// backend. It is always trimmed to the length of the video.
+type backgroundAudio struct {
+	path string
+	// volume is a percentage of the original.
+	volume  float64
+	fadeIn  float64
+	fadeOut float64
+	// loop repeats audio shorter than the video.
+	loop bool
+}
+
+func (b backgroundAudio) active() bool {
+	return b.path != ""
+}
+
+// ffmpegInput returns the arguments that add the audio as an input.
+func (b backgroundAudio) ffmpegInput() []string {
+	p, _ := filepath.Abs(b.path)
+	if b.loop {
+		return []string{"-stream_loop", "-1", "-i", p}
+	}
+	return []string{"-i", p}
+}
+
+// ffmpegFilter returns the audio filter for a video length seconds long.
+func (b backgroundAudio) ffmpegFilter(length float64) string {
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	filters := []string{"atrim=duration=" + format(length)}
+	if b.fadeIn > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:st=0:d=%s", format(b.fadeIn)))
+	}
+	if b.fadeOut > 0 {
+		start := math.Max(0, length-b.fadeOut)
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%s:d=%s", format(start), format(b.fadeOut)))
+	}
+	if b.volume != 100 {
+		filters = append(filters, "volume="+format(b.volume/100))
+	}
+	return strings.Join(filters, ",")
+}
+
+func (e *encoder) setupAudio() *widget.AccordionItem {
+	p := a.Preferences()
+	b := &e.audio
+
+	pathLabel := widget.NewLabel("Audio file")
+	pathInput := widget.NewEntry()
+	pathInput.SetPlaceHolder("None")
+	pathInput.SetText(p.String("audioPath"))
+	b.path = pathInput.Text
+	pathInput.OnChanged = func(s string) {
+		b.path = s
+		p.SetString("audioPath", s)
+	}
+	pathOpen := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
+		if err != nil || uc == nil {
+			return
+		}
+		uc.Close()
+		pathInput.SetText(uc.URI().Path())
+	}, window)
+	pathButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		pathOpen.Show()
+	})
+	clearButton := widget.NewButtonWithIcon("", theme.ContentClearIcon(), func() {
+		pathInput.SetText("")
+	})
+
+	loopLabel := widget.NewLabel("Loop")
+	loopCheck := widget.NewCheck("Repeat audio shorter than the video", func(value bool) {
+		b.loop = value
+		p.SetBool("audioLoop", value)
+	})
+	loopCheck.SetChecked(p.BoolWithFallback("audioLoop", true))
+
+	return widget.NewAccordionItem("Audio", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), pathLabel), nil,
+			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, pathButton, clearButton), pathInput),
+		),
+		makeRow("Volume %", makeFloatEntry("audioVolume", 100, &b.volume)),
+		makeRow("Fade in (s)", makeFloatEntry("audioFadeIn", 0, &b.fadeIn)),
+		makeRow("Fade out (s)", makeFloatEntry("audioFadeOut", 0, &b.fadeOut)),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), loopLabel), nil, loopCheck),
+		widget.NewLabel("Audio is added to the webm, mp4 and other video outputs of the ffmpeg backend,\ntrimmed to the length of the video."),
+	))
+}
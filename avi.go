@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// maxAVIRate caps the frame rate used to approximate differing frame times.
+const maxAVIRate = 60
+
+// maxAVISize is the largest file written. Plain AVI holds up to 4 GB in its
+// 32-bit sizes and offsets, but many players stop reading after 1 GB.
+const maxAVISize = 1 << 30
+
+// aviSink writes frames as Motion-JPEG in an AVI container. AVI only has a
+// constant frame rate, so frames shown for longer are repeated.
+type aviSink struct {
+	w       io.WriteSeeker
+	canvas  image.Rectangle
+	quality int
+	rate    float64
+
+	pos      int64
+	moviPos  int64
+	index    []aviIndexEntry
+	maxChunk uint32
+	elapsed  float64
+	buf      bytes.Buffer
+
+	// Offsets of header fields only known once every frame is written.
+	totalFramesPos, maxBytesPos, avihBufferPos, strhLengthPos, strhBufferPos int64
+}
+
+type aviIndexEntry struct {
+	offset, size uint32
+}
+
+func newAVISink(w io.WriteSeeker, canvas image.Rectangle, delays []float64, quality int) (*aviSink, error) {
+	s := &aviSink{w: w, canvas: canvas, quality: quality}
+	if fr, ok := uniformRate(delays); ok {
+		s.rate = fr
+	} else {
+		shortest := delays[0]
+		for _, d := range delays {
+			shortest = math.Min(shortest, d)
+		}
+		s.rate = math.Min(1/shortest, maxAVIRate)
+	}
+	return s, s.writeHeader()
+}
+
+func (s *aviSink) write(b []byte) error {
+	n, err := s.w.Write(b)
+	s.pos += int64(n)
+	return err
+}
+
+func (s *aviSink) writeFourCC(cc string) error {
+	return s.write([]byte(cc))
+}
+
+func (s *aviSink) write32(v uint32) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return s.write(b[:])
+}
+
+// writeHeader writes the RIFF, hdrl and movi headers, leaving the sizes and
+// frame counts to be filled in by close.
+func (s *aviSink) writeHeader() error {
+	w, h := uint32(s.canvas.Dx()), uint32(s.canvas.Dy())
+	var b bytes.Buffer
+	u32 := func(v uint32) {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	u16 := func(v uint16) {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	pos := func() int64 {
+		return int64(b.Len())
+	}
+
+	b.WriteString("RIFF")
+	u32(0) // patched on close
+	b.WriteString("AVI ")
+
+	b.WriteString("LIST")
+	u32(4 + 8 + 56 + 8 + 4 + 8 + 56 + 8 + 40)
+	b.WriteString("hdrl")
+
+	b.WriteString("avih")
+	u32(56)
+	u32(uint32(math.Round(1e6 / s.rate)))
+	s.maxBytesPos = pos()
+	u32(0)
+	u32(0)
+	u32(0x10) // AVIF_HASINDEX
+	s.totalFramesPos = pos()
+	u32(0)
+	u32(0)
+	u32(1)
+	s.avihBufferPos = pos()
+	u32(0)
+	u32(w)
+	u32(h)
+	b.Write(make([]byte, 16))
+
+	b.WriteString("LIST")
+	u32(4 + 8 + 56 + 8 + 40)
+	b.WriteString("strl")
+
+	b.WriteString("strh")
+	u32(56)
+	b.WriteString("vidsMJPG")
+	u32(0)
+	u16(0)
+	u16(0)
+	u32(0)
+	u32(1000)
+	u32(uint32(math.Round(s.rate * 1000)))
+	u32(0)
+	s.strhLengthPos = pos()
+	u32(0)
+	s.strhBufferPos = pos()
+	u32(0)
+	u32(math.MaxUint32)
+	u32(0)
+	u16(0)
+	u16(0)
+	u16(uint16(w))
+	u16(uint16(h))
+
+	b.WriteString("strf")
+	u32(40)
+	u32(40)
+	u32(w)
+	u32(h)
+	u16(1)
+	u16(24)
+	b.WriteString("MJPG")
+	u32(w * h * 3)
+	b.Write(make([]byte, 16))
+
+	b.WriteString("LIST")
+	u32(0) // patched on close
+	s.moviPos = pos()
+	b.WriteString("movi")
+	return s.write(b.Bytes())
+}
+
+func (s *aviSink) add(m *image.RGBA, delay float64) error {
+	start := int(math.Round(s.elapsed * s.rate))
+	s.elapsed += delay
+	repeat := int(math.Round(s.elapsed*s.rate)) - start
+	if len(s.index) == 0 && repeat < 1 {
+		repeat = 1
+	}
+	if repeat < 1 {
+		return nil
+	}
+
+	s.buf.Reset()
+	if err := jpeg.Encode(&s.buf, m, &jpeg.Options{Quality: s.quality}); err != nil {
+		return err
+	}
+	data := s.buf.Bytes()
+	size := uint32(len(data))
+	// Each repeat takes its chunk and an index entry.
+	grow := int64(repeat) * (8 + int64(size+size%2) + 16)
+	if s.pos+grow+8+int64(len(s.index))*16 > maxAVISize {
+		return fmt.Errorf("avi output would exceed %d MB, lower the quality or frame count", maxAVISize>>20)
+	}
+	if size > s.maxChunk {
+		s.maxChunk = size
+	}
+	for i := 0; i < repeat; i++ {
+		s.index = append(s.index, aviIndexEntry{offset: uint32(s.pos - s.moviPos), size: size})
+		if err := s.writeFourCC("00dc"); err != nil {
+			return err
+		}
+		if err := s.write32(size); err != nil {
+			return err
+		}
+		if err := s.write(data); err != nil {
+			return err
+		}
+		if size%2 == 1 {
+			if err := s.write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// patch overwrites a 32-bit header field at off.
+func (s *aviSink) patch(off int64, v uint32) error {
+	if _, err := s.w.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	_, err := s.w.Write(b[:])
+	return err
+}
+
+func (s *aviSink) close() error {
+	if len(s.index) == 0 {
+		return fmt.Errorf("no frames were decoded")
+	}
+	moviSize := uint32(s.pos - s.moviPos)
+
+	var idx bytes.Buffer
+	idx.WriteString("idx1")
+	binary.Write(&idx, binary.LittleEndian, uint32(len(s.index)*16))
+	for _, entry := range s.index {
+		idx.WriteString("00dc")
+		binary.Write(&idx, binary.LittleEndian, []uint32{0x10, entry.offset, entry.size}) // AVIIF_KEYFRAME
+	}
+	if err := s.write(idx.Bytes()); err != nil {
+		return err
+	}
+
+	frames := uint32(len(s.index))
+	for _, p := range []struct {
+		off int64
+		v   uint32
+	}{
+		{4, uint32(s.pos - 8)},
+		{s.moviPos - 4, moviSize},
+		{s.maxBytesPos, uint32(math.Min(float64(s.maxChunk)*s.rate, math.MaxUint32))},
+		{s.totalFramesPos, frames},
+		{s.avihBufferPos, s.maxChunk + 8},
+		{s.strhLengthPos, frames},
+		{s.strhBufferPos, s.maxChunk + 8},
+	} {
+		if err := s.patch(p.off, p.v); err != nil {
+			return err
+		}
+	}
+	_, err := s.w.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readTestAVI returns the frame rate of an AVI and its frames in index order.
+func readTestAVI(t *testing.T, b []byte) (float64, []*image.RGBA) {
+	t.Helper()
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "AVI " {
+		t.Fatal("not an AVI file")
+	}
+	if size := binary.LittleEndian.Uint32(b[4:8]); int(size) != len(b)-8 {
+		t.Fatalf("RIFF size %d, file holds %d", size, len(b)-8)
+	}
+	var movi int
+	var index []byte
+	var rate float64
+	var frames uint32
+	for pos := 12; pos+8 <= len(b); {
+		id, size := string(b[pos:pos+4]), int(binary.LittleEndian.Uint32(b[pos+4:pos+8]))
+		body := b[pos+8 : pos+8+size]
+		switch {
+		case id == "LIST" && string(body[:4]) == "hdrl":
+			avih := body[4+8:]
+			rate = 1e6 / float64(binary.LittleEndian.Uint32(avih[0:4]))
+			frames = binary.LittleEndian.Uint32(avih[16:20])
+		case id == "LIST" && string(body[:4]) == "movi":
+			movi = pos + 8
+		case id == "idx1":
+			index = body
+		}
+		pos += 8 + size + size%2
+	}
+	if movi == 0 || index == nil {
+		t.Fatal("missing movi list or idx1 index")
+	}
+	if int(frames) != len(index)/16 {
+		t.Errorf("header counts %d frames, index holds %d", frames, len(index)/16)
+	}
+
+	var decoded []*image.RGBA
+	for i := 0; i+16 <= len(index); i += 16 {
+		off := movi + int(binary.LittleEndian.Uint32(index[i+8:]))
+		size := int(binary.LittleEndian.Uint32(index[i+12:]))
+		if string(index[i:i+4]) != "00dc" || string(b[off:off+4]) != "00dc" {
+			t.Fatalf("index entry %d does not point at a frame", i/16)
+		}
+		m, err := jpeg.Decode(bytes.NewReader(b[off+8 : off+8+size]))
+		if err != nil {
+			t.Fatalf("frame %d: %v", i/16, err)
+		}
+		rgba := image.NewRGBA(m.Bounds())
+		draw.Draw(rgba, rgba.Rect, m, m.Bounds().Min, draw.Src)
+		decoded = append(decoded, rgba)
+	}
+	return rate, decoded
+}
+
+// nearestFrame returns the index of the frame m differs least from.
+func nearestFrame(m *image.RGBA, frames []*image.RGBA) int {
+	best, bestDiff := -1, math.MaxInt
+	for i, f := range frames {
+		var diff int
+		for j := range f.Pix {
+			d := int(f.Pix[j]) - int(m.Pix[j])
+			diff += d * d
+		}
+		if diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	return best
+}
+
+func TestAVISinkRoundTrip(t *testing.T) {
+	frames := testFrames(3, image.Pt(48, 32))
+	for _, tt := range []struct {
+		name    string
+		delays  []float64
+		rate    float64
+		repeats []int
+	}{
+		{"uniform", []float64{0.04, 0.04, 0.02}, 25, []int{1, 1, 1}},
+		{"repeats longer frames", []float64{0.1, 0.3, 0.2}, 10, []int{1, 3, 2}},
+		{"repeats a held last frame", []float64{0.1, 0.1, 0.5}, 10, []int{1, 1, 5}},
+		{"caps the rate", []float64{0.01, 0.05, 0.1}, maxAVIRate, []int{1, 3, 6}},
+	} {
+		p := filepath.Join(t.TempDir(), "out.avi")
+		writeTestOutput(t, p, frames, tt.delays)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rate, decoded := readTestAVI(t, b)
+		if math.Abs(rate-tt.rate) > 0.01 {
+			t.Errorf("%s: %v fps, want %v", tt.name, rate, tt.rate)
+		}
+		var want []int
+		for i, n := range tt.repeats {
+			for j := 0; j < n; j++ {
+				want = append(want, i)
+			}
+		}
+		if len(decoded) != len(want) {
+			t.Errorf("%s: %d frames, want %d", tt.name, len(decoded), len(want))
+			continue
+		}
+		for i, src := range want {
+			if n := nearestFrame(decoded[i], frames); n != src || !closeTo(decoded[i], frames[src], 4) {
+				t.Errorf("%s: frame %d shows source frame %d, want %d", tt.name, i, n, src)
+			}
+		}
+	}
+}
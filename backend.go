@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// encoderBackend turns the frames of an encode into output files. Backends
+// register themselves with registerBackend and are offered in the Settings
+// tab in the order they were registered.
+type encoderBackend interface {
+	// name identifies the backend in the Settings tab, preferences and the
+	// queue.
+	name() string
+	// available reports whether the tools the backend relies on were found.
+	available() bool
+	// formats returns the output types the backend can currently write.
+	formats() []string
+	// encode writes frames to job.Output with one file for each of kinds,
+	// reporting progress through e.
+	encode(e *encoder, job *encodeJob, kinds []string, frames *encodeFrames) error
+}
+
+// fileBackend is implemented by backends that read frames from disk instead
+// of through encodeFrames.load. Frames that need gosh to render them are
+// staged as files first.
+type fileBackend interface {
+	// mixedFormats reports whether the backend can read frames stored in
+	// differing formats in one run.
+	mixedFormats() bool
+	// layouts reports whether the backend crops, scales and pads frames
+	// itself, following encodeFrames.layout.
+	layouts() bool
+}
+
+// interpolatingBackend is implemented by backends that can generate
+// in-between frames themselves at a constant frame rate.
+type interpolatingBackend interface {
+	interpolates() bool
+}
+
+// encodeFrames holds the frames of an encode, both as files and as a loader
+// of decoded frames.
+type encodeFrames struct {
+	// dir and files locate the frames on disk, which are base in size and
+	// still need layout applied.
+	dir    string
+	files  []string
+	base   image.Point
+	layout frameLayout
+
+	// load returns frames with geometry, text and interpolation applied,
+	// sized to canvas.
+	load   frameLoader
+	canvas image.Rectangle
+
+	// delays holds how long each frame is shown, in seconds. fps is set if
+	// they are uniform.
+	delays  []float64
+	fps     float64
+	uniform bool
+
+	// interpolate asks an interpolatingBackend to generate the in-between
+	// frames itself.
+	interpolate bool
+}
+
+var backends []encoderBackend
+
+func registerBackend(b encoderBackend) {
+	backends = append(backends, b)
+}
+
+// findBackend returns the registered backend called name, or nil.
+func findBackend(name string) encoderBackend {
+	for _, b := range backends {
+		if b.name() == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// backendNames returns the names of every registered backend.
+func backendNames() (names []string) {
+	for _, b := range backends {
+		names = append(names, b.name())
+	}
+	return
+}
+
+// parseBackendOrder reads a comma separated list of backend names.
+func parseBackendOrder(s string) ([]encoderBackend, error) {
+	var order []encoderBackend
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		b := findBackend(name)
+		if b == nil {
+			return nil, fmt.Errorf("unknown backend %q", name)
+		}
+		order = append(order, b)
+	}
+	return order, nil
+}
+
+// autoOrder returns every registered backend, those named in the
+// backendOrder preference first.
+func autoOrder() []encoderBackend {
+	order, _ := parseBackendOrder(a.Preferences().String("backendOrder"))
+	for _, b := range backends {
+		listed := false
+		for _, o := range order {
+			listed = listed || o == b
+		}
+		if !listed {
+			order = append(order, b)
+		}
+	}
+	return order
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const trashDir = ".trash"
+
+type frameBrowser struct {
+	container *fyne.Container
+	list      *widget.List
+	preview   *canvas.Image
+	dirLabel  *widget.Label
+	infoLabel *widget.Label
+	undoBtn   *widget.Button
+
+	dir      string
+	frames   []string
+	selected map[string]bool
+	inFrame  string
+	outFrame string
+	focused  int
+	// selectionLock guards dir, selected, inFrame and outFrame, which
+	// encodes read through filter while the UI changes them.
+	selectionLock sync.Mutex
+
+	thumbs     map[string]image.Image
+	loading    map[string]bool
+	thumbsLock sync.Mutex
+	thumbLimit chan struct{}
+
+	// trashed holds each batch of deleted frames so they can be restored.
+	trashed [][]string
+}
+
+func (b *frameBrowser) setup() {
+	b.selected = make(map[string]bool)
+	b.thumbs = make(map[string]image.Image)
+	b.loading = make(map[string]bool)
+	b.thumbLimit = make(chan struct{}, 4)
+	b.focused = -1
+
+	b.dirLabel = widget.NewLabel("")
+	b.dirLabel.Wrapping = fyne.TextTruncate
+	refreshButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+		b.refresh()
+	})
+
+	b.list = widget.NewList(
+		func() int {
+			return len(b.frames)
+		},
+		func() fyne.CanvasObject {
+			thumb := canvas.NewImageFromImage(nil)
+			thumb.FillMode = canvas.ImageFillContain
+			thumb.SetMinSize(fyne.NewSize(96, 54))
+			return container.NewBorder(nil, nil,
+				container.NewHBox(widget.NewCheck("", nil), thumb),
+				nil,
+				container.NewVBox(widget.NewLabel(""), widget.NewLabel("")),
+			)
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			if id >= len(b.frames) {
+				return
+			}
+			name := b.frames[id]
+			c := o.(*fyne.Container)
+			left := c.Objects[1].(*fyne.Container)
+			check := left.Objects[0].(*widget.Check)
+			thumb := left.Objects[1].(*canvas.Image)
+			labels := c.Objects[0].(*fyne.Container)
+
+			check.OnChanged = nil
+			check.SetChecked(b.selected[name])
+			check.OnChanged = func(value bool) {
+				b.selectionLock.Lock()
+				if value {
+					b.selected[name] = true
+				} else {
+					delete(b.selected, name)
+				}
+				b.selectionLock.Unlock()
+				b.refreshInfo()
+			}
+
+			thumb.Image = b.thumbnail(name)
+			thumb.Refresh()
+
+			labels.Objects[0].(*widget.Label).SetText(frameTime(b.dir, name).Format("2006-01-02 15:04:05.000"))
+			var marks []string
+			if name == b.inFrame {
+				marks = append(marks, "in")
+			}
+			if name == b.outFrame {
+				marks = append(marks, "out")
+			}
+			if !b.inRange(id) {
+				marks = append(marks, "excluded")
+			}
+			labels.Objects[1].(*widget.Label).SetText(strings.Join(append([]string{name}, marks...), " · "))
+		},
+	)
+	b.list.OnSelected = func(id widget.ListItemID) {
+		b.focused = id
+		b.showPreview(b.frames[id])
+	}
+
+	b.preview = canvas.NewImageFromImage(nil)
+	b.preview.FillMode = canvas.ImageFillContain
+	b.preview.SetMinSize(fyne.NewSize(240, 135))
+
+	inButton := widget.NewButton("In", func() {
+		if b.focused >= 0 && b.focused < len(b.frames) {
+			b.selectionLock.Lock()
+			b.inFrame = b.frames[b.focused]
+			b.selectionLock.Unlock()
+			b.list.Refresh()
+			b.refreshInfo()
+		}
+	})
+	outButton := widget.NewButton("Out", func() {
+		if b.focused >= 0 && b.focused < len(b.frames) {
+			b.selectionLock.Lock()
+			b.outFrame = b.frames[b.focused]
+			b.selectionLock.Unlock()
+			b.list.Refresh()
+			b.refreshInfo()
+		}
+	})
+	clearRangeButton := widget.NewButton("Clear range", func() {
+		b.selectionLock.Lock()
+		b.inFrame, b.outFrame = "", ""
+		b.selectionLock.Unlock()
+		b.list.Refresh()
+		b.refreshInfo()
+	})
+	selectAllButton := widget.NewButtonWithIcon("", theme.CheckButtonCheckedIcon(), func() {
+		b.selectionLock.Lock()
+		for _, name := range b.frames {
+			b.selected[name] = true
+		}
+		b.selectionLock.Unlock()
+		b.list.Refresh()
+		b.refreshInfo()
+	})
+	selectNoneButton := widget.NewButtonWithIcon("", theme.CheckButtonIcon(), func() {
+		b.selectionLock.Lock()
+		b.selected = make(map[string]bool)
+		b.selectionLock.Unlock()
+		b.list.Refresh()
+		b.refreshInfo()
+	})
+	deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		b.deleteSelected()
+	})
+	b.undoBtn = widget.NewButtonWithIcon("", theme.ContentUndoIcon(), func() {
+		b.undoDelete()
+	})
+	b.undoBtn.Disable()
+	fullButton := widget.NewButtonWithIcon("", theme.ZoomInIcon(), func() {
+		if b.focused >= 0 && b.focused < len(b.frames) {
+			b.showFullSize(b.frames[b.focused])
+		}
+	})
+
+	b.infoLabel = widget.NewLabel("")
+
+	b.container = container.NewBorder(
+		container.NewVBox(
+			container.NewBorder(nil, nil, nil, refreshButton, b.dirLabel),
+			container.NewHBox(inButton, outButton, clearRangeButton, widget.NewSeparator(), selectAllButton, selectNoneButton, deleteButton, b.undoBtn, widget.NewSeparator(), fullButton),
+		),
+		b.infoLabel,
+		nil,
+		nil,
+		container.NewHSplit(b.list, b.preview),
+	)
+}
+
+// refresh reloads the frame list from the encoder's input directory.
+func (b *frameBrowser) refresh() {
+	dir := a.Preferences().String("encoderInputDir")
+	if dir != b.dir {
+		b.selectionLock.Lock()
+		b.dir = dir
+		b.selected = make(map[string]bool)
+		b.inFrame, b.outFrame = "", ""
+		b.selectionLock.Unlock()
+		b.trashed = nil
+		b.undoBtn.Disable()
+		b.thumbsLock.Lock()
+		b.thumbs = make(map[string]image.Image)
+		b.thumbsLock.Unlock()
+	}
+	b.dirLabel.SetText(dir)
+
+	frames, err := aEncoder.discovery.discover(dir)
+	if err != nil {
+		b.frames = nil
+		b.infoLabel.SetText(err.Error())
+		b.list.Refresh()
+		return
+	}
+	b.frames = frames
+
+	exists := make(map[string]bool)
+	for _, name := range frames {
+		exists[name] = true
+	}
+	b.selectionLock.Lock()
+	for name := range b.selected {
+		if !exists[name] {
+			delete(b.selected, name)
+		}
+	}
+	if !exists[b.inFrame] {
+		b.inFrame = ""
+	}
+	if !exists[b.outFrame] {
+		b.outFrame = ""
+	}
+	b.selectionLock.Unlock()
+	if b.focused >= len(frames) {
+		b.focused = -1
+	}
+
+	b.list.UnselectAll()
+	b.list.Refresh()
+	b.refreshInfo()
+}
+
+func (b *frameBrowser) refreshInfo() {
+	n := len(b.filter(b.dir, b.frames))
+	b.infoLabel.SetText(fmt.Sprintf("%d frames, %d selected, %d will be encoded", len(b.frames), len(b.selected), n))
+}
+
+// inRange reports whether the frame at index i lies within the in/out points.
+func (b *frameBrowser) inRange(i int) bool {
+	for j, name := range b.frames {
+		if name == b.inFrame && i < j {
+			return false
+		}
+		if name == b.outFrame && i > j {
+			return false
+		}
+	}
+	return true
+}
+
+// frameSelection is the range and frames marked in the browser for a folder.
+type frameSelection struct {
+	dir      string
+	selected map[string]bool
+	inFrame  string
+	outFrame string
+}
+
+// selection returns a copy of what is currently marked, for an encode that
+// runs later.
+func (b *frameBrowser) selection() *frameSelection {
+	b.selectionLock.Lock()
+	defer b.selectionLock.Unlock()
+	s := &frameSelection{dir: b.dir, selected: make(map[string]bool, len(b.selected)), inFrame: b.inFrame, outFrame: b.outFrame}
+	for name := range b.selected {
+		s.selected[name] = true
+	}
+	return s
+}
+
+// filter narrows files from dir down to the marked range and, if any frames
+// are selected, to the selection.
+func (b *frameBrowser) filter(dir string, files []string) []string {
+	b.selectionLock.Lock()
+	defer b.selectionLock.Unlock()
+	return frameSelection{dir: b.dir, selected: b.selected, inFrame: b.inFrame, outFrame: b.outFrame}.filter(dir, files)
+}
+
+func (s frameSelection) filter(dir string, files []string) []string {
+	if s.dir == "" || filepath.Clean(dir) != filepath.Clean(s.dir) {
+		return files
+	}
+	start, end := 0, len(files)-1
+	for i, name := range files {
+		if name == s.inFrame {
+			start = i
+		}
+		if name == s.outFrame {
+			end = i
+		}
+	}
+	var out []string
+	for i := start; i <= end && i < len(files); i++ {
+		if len(s.selected) == 0 || s.selected[files[i]] {
+			out = append(out, files[i])
+		}
+	}
+	return out
+}
+
+func (b *frameBrowser) thumbnail(name string) image.Image {
+	b.thumbsLock.Lock()
+	defer b.thumbsLock.Unlock()
+	if m, ok := b.thumbs[name]; ok {
+		return m
+	}
+	if b.loading[name] {
+		return nil
+	}
+	b.loading[name] = true
+	dir := b.dir
+	go func() {
+		b.thumbLimit <- struct{}{}
+		defer func() { <-b.thumbLimit }()
+		m, err := decodeImage(filepath.Join(dir, name))
+		b.thumbsLock.Lock()
+		delete(b.loading, name)
+		if err != nil {
+			log.Println("Error loading thumbnail", err)
+			b.thumbsLock.Unlock()
+			return
+		}
+		if dir == b.dir {
+			b.thumbs[name] = thumbnailImage(m, 96, 54)
+		}
+		b.thumbsLock.Unlock()
+		b.list.Refresh()
+	}()
+	return nil
+}
+
+// thumbnailImage shrinks m to fit within w×h by averaging source pixels.
+func thumbnailImage(m image.Image, w, h int) image.Image {
+	src := toRGBA(m)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if sw == 0 || sh == 0 {
+		return src
+	}
+	if sw*h > sh*w {
+		h = sh * w / sw
+	} else {
+		w = sw * h / sh
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			var sum [4]int
+			n := 0
+			for sy := y0; sy < y1; sy++ {
+				o := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[o])
+					sum[1] += int(src.Pix[o+1])
+					sum[2] += int(src.Pix[o+2])
+					sum[3] += int(src.Pix[o+3])
+					o += 4
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			d := dst.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				dst.Pix[d+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+func (b *frameBrowser) showPreview(name string) {
+	dir := b.dir
+	go func() {
+		m, err := decodeImage(filepath.Join(dir, name))
+		if err != nil {
+			b.infoLabel.SetText(err.Error())
+			return
+		}
+		b.preview.Image = m
+		b.preview.Refresh()
+	}()
+}
+
+func (b *frameBrowser) showFullSize(name string) {
+	m, err := decodeImage(filepath.Join(b.dir, name))
+	if err != nil {
+		b.infoLabel.SetText(err.Error())
+		return
+	}
+	img := canvas.NewImageFromImage(m)
+	img.FillMode = canvas.ImageFillOriginal
+	w := a.NewWindow(name)
+	w.SetContent(container.NewScroll(img))
+	size := fyne.NewSize(float32(m.Bounds().Dx()), float32(m.Bounds().Dy()))
+	if size.Width > 1280 {
+		size.Width = 1280
+	}
+	if size.Height > 800 {
+		size.Height = 800
+	}
+	w.Resize(size)
+	w.Show()
+}
+
+// deleteSelected moves the selected frames into the trash folder.
+func (b *frameBrowser) deleteSelected() {
+	if len(b.selected) == 0 {
+		return
+	}
+	trash := filepath.Join(b.dir, trashDir)
+	if err := os.MkdirAll(trash, 0755); err != nil {
+		b.infoLabel.SetText(err.Error())
+		return
+	}
+	var batch []string
+	for _, name := range b.frames {
+		if !b.selected[name] {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(trash, name)), 0755); err != nil {
+			log.Println("Error creating trash folder", err)
+			continue
+		}
+		if err := os.Rename(filepath.Join(b.dir, name), filepath.Join(trash, name)); err != nil {
+			log.Println("Error moving frame to trash", err)
+			continue
+		}
+		batch = append(batch, name)
+	}
+	if len(batch) > 0 {
+		b.trashed = append(b.trashed, batch)
+		b.undoBtn.Enable()
+	}
+	b.selectionLock.Lock()
+	b.selected = make(map[string]bool)
+	b.selectionLock.Unlock()
+	b.refresh()
+}
+
+// undoDelete restores the most recently deleted batch of frames.
+func (b *frameBrowser) undoDelete() {
+	if len(b.trashed) == 0 {
+		return
+	}
+	batch := b.trashed[len(b.trashed)-1]
+	b.trashed = b.trashed[:len(b.trashed)-1]
+	trash := filepath.Join(b.dir, trashDir)
+	for _, name := range batch {
+		if err := os.Rename(filepath.Join(trash, name), filepath.Join(b.dir, name)); err != nil {
+			log.Println("Error restoring frame", err)
+		}
+	}
+	if len(b.trashed) == 0 {
+		b.undoBtn.Disable()
+	}
+	b.refresh()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrameSelectionFilter(t *testing.T) {
+	files := []string{"1.png", "2.png", "3.png", "4.png", "5.png"}
+	for _, tt := range []struct {
+		name string
+		s    frameSelection
+		dir  string
+		want []string
+	}{
+		{"nothing marked", frameSelection{dir: "frames"}, "frames", files},
+		{"range", frameSelection{dir: "frames", inFrame: "2.png", outFrame: "4.png"}, "frames", []string{"2.png", "3.png", "4.png"}},
+		{"selection within range", frameSelection{dir: "frames", inFrame: "2.png", selected: map[string]bool{"1.png": true, "3.png": true}}, "frames", []string{"3.png"}},
+		{"other folder", frameSelection{dir: "frames", selected: map[string]bool{"3.png": true}}, "other", files},
+		{"same folder, other spelling", frameSelection{dir: "frames/", selected: map[string]bool{"3.png": true}}, "./frames", []string{"3.png"}},
+	} {
+		if got := tt.s.filter(tt.dir, files); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrameBrowserSelectionIsACopy(t *testing.T) {
+	b := &frameBrowser{dir: "frames", selected: map[string]bool{"2.png": true}, inFrame: "1.png"}
+	s := b.selection()
+	b.selected["3.png"] = true
+	b.inFrame = "3.png"
+	b.dir = "other"
+	want := &frameSelection{dir: "frames", selected: map[string]bool{"2.png": true}, inFrame: "1.png"}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+}
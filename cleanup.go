@@ -0,0 +1,488 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var cleanupActions = []string{"keep", "delete", "move", "zip", "tar.zst", "thin"}
+
+// frameCleanup decides what happens to the frames of an encode once its
+// outputs are written and verified.
+type frameCleanup struct {
+	action string
+	// archive is where frames are moved or archived to. If empty, they go
+	// beside the output.
+	archive string
+	// every keeps every n-th frame when thinning.
+	every int
+}
+
+// destructive reports whether the action removes frames that are not kept
+// anywhere else.
+func (c frameCleanup) destructive() bool {
+	return c.action != "keep" && c.action != "move"
+}
+
+// destination returns where frames of job are moved or archived to, with ext
+// added for archives.
+func (c frameCleanup) destination(job *encodeJob, ext string) string {
+	name := filepath.Base(job.Output) + "-frames" + ext
+	if c.archive != "" {
+		return filepath.Join(c.archive, name)
+	}
+	return filepath.Join(filepath.Dir(job.Output), name)
+}
+
+// verifyOutput checks that p exists and decodes. GIF, APNG and AVI, which
+// the integrated backend writes, are decoded here, anything else by ffmpeg.
+func verifyOutput(p string) error {
+	st, err := os.Stat(p)
+	if err != nil {
+		return err
+	} else if st.Size() == 0 {
+		return fmt.Errorf("%s is empty", p)
+	}
+	switch ext := strings.ToLower(filepath.Ext(p)); ext {
+	case ".gif", ".png", ".avi":
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		switch ext {
+		case ".gif":
+			_, err = gif.DecodeAll(bufio.NewReader(f))
+		case ".png":
+			err = verifyPNG(f)
+		default:
+			err = verifyAVI(f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s does not decode: %w", p, err)
+		}
+		return nil
+	}
+	if !aSettings.ffmpegCaps.probed() {
+		return fmt.Errorf("ffmpeg is needed to verify %s", p)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(aSettings.getFFMPEGPath(), "-v", "error", "-i", p, "-f", "null", "-")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s does not decode: %v\n%s", p, err, stderr.String())
+	}
+	return nil
+}
+
+// verifyPNG decodes the default image of a PNG, then checks the CRC of every
+// chunk, the frames of an APNG included, up to IEND.
+func verifyPNG(f io.ReadSeeker) error {
+	if _, err := png.Decode(bufio.NewReader(f)); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	if _, err := r.Discard(8); err != nil {
+		return err
+	}
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return fmt.Errorf("truncated: %w", err)
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(head[4:])
+		if _, err := io.CopyN(crc, r, int64(binary.BigEndian.Uint32(head[:4]))); err != nil {
+			return fmt.Errorf("truncated %s chunk: %w", head[4:], err)
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return fmt.Errorf("truncated %s chunk: %w", head[4:], err)
+		} else if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+			return fmt.Errorf("%s chunk is corrupt", head[4:])
+		}
+		if string(head[4:]) == "IEND" {
+			return nil
+		}
+	}
+}
+
+// verifyAVI checks the RIFF structure and index of an AVI, then decodes the
+// first frame the index points to as JPEG.
+func verifyAVI(f io.ReadSeeker) error {
+	var head [12]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil {
+		return err
+	} else if string(head[:4]) != "RIFF" || string(head[8:]) != "AVI " {
+		return fmt.Errorf("not an AVI")
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(head[4:8]))
+	var movi int64
+	var first *aviIndexEntry
+	for pos := int64(12); pos < end; {
+		var chunk [8]byte
+		if _, err := io.ReadFull(f, chunk[:]); err != nil {
+			return fmt.Errorf("truncated at %d: %w", pos, err)
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+		if id == "LIST" {
+			var kind [4]byte
+			if _, err := io.ReadFull(f, kind[:]); err != nil {
+				return err
+			}
+			if string(kind[:]) == "movi" {
+				movi = pos + 8
+			}
+		} else if id == "idx1" {
+			if size < 16 {
+				return fmt.Errorf("the index is empty")
+			}
+			var entry [16]byte
+			if _, err := io.ReadFull(f, entry[:]); err != nil {
+				return err
+			}
+			first = &aviIndexEntry{binary.LittleEndian.Uint32(entry[8:12]), binary.LittleEndian.Uint32(entry[12:])}
+		}
+		pos += 8 + size + size%2
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if movi == 0 {
+		return fmt.Errorf("no movi list")
+	} else if first == nil {
+		return fmt.Errorf("no idx1 index")
+	}
+	if _, err := f.Seek(movi+int64(first.offset), io.SeekStart); err != nil {
+		return err
+	}
+	var chunk [8]byte
+	if _, err := io.ReadFull(f, chunk[:]); err != nil {
+		return err
+	} else if string(chunk[:4]) != "00dc" || binary.LittleEndian.Uint32(chunk[4:]) != first.size {
+		return fmt.Errorf("the index does not point at a frame")
+	}
+	_, err := jpeg.Decode(io.LimitReader(f, int64(first.size)))
+	return err
+}
+
+// cleanupFrames verifies the outputs of job, then cleans up its frames,
+// asking first if they would be lost.
+func (e *encoder) cleanupFrames(job *encodeJob) {
+	c := e.cleanup
+	if c.action == "keep" || c.action == "" {
+		return
+	}
+	fail := func(err error) {
+		job.logf("frames kept: %s", err)
+		e.report("frames kept: " + err.Error())
+	}
+	if len(job.outputs()) == 0 {
+		fail(fmt.Errorf("no outputs to verify"))
+		return
+	}
+	for _, p := range job.outputs() {
+		if err := verifyOutput(p); err != nil {
+			fail(err)
+			return
+		}
+	}
+	job.logf("verified %s", strings.Join(job.outputs(), ", "))
+	files := job.encodedFrames()
+	if len(files) == 0 {
+		fail(fmt.Errorf("no encoded frames recorded"))
+		return
+	}
+
+	run := func() {
+		if err := c.apply(job, files); err != nil {
+			fail(err)
+			return
+		}
+		e.report(fmt.Sprintf("complete, frames cleaned up (%s)", c.action))
+	}
+	if !c.destructive() {
+		run()
+		return
+	}
+	var message string
+	switch c.action {
+	case "delete":
+		message = fmt.Sprintf("Delete the %d frames in %s?", len(files), job.Input)
+	case "thin":
+		message = fmt.Sprintf("Keep one in every %d of the %d frames in %s and delete the rest?", c.every, len(files), job.Input)
+	default:
+		message = fmt.Sprintf("Archive the %d frames in %s to %s and delete them?", len(files), job.Input, c.destination(job, "."+c.action))
+	}
+	dialog.ShowConfirm("Clean up frames", message+"\n\nThe outputs were verified.", func(ok bool) {
+		if ok {
+			go run()
+		} else {
+			job.logf("frames kept")
+		}
+	}, window)
+}
+
+// apply cleans up files, the frames of job.
+func (c frameCleanup) apply(job *encodeJob, files []string) error {
+	var remove []string
+	switch c.action {
+	case "delete":
+		remove = files
+	case "thin":
+		if c.every < 2 {
+			return fmt.Errorf("thinning needs a step of at least 2")
+		}
+		for i, f := range files {
+			if i%c.every != 0 {
+				remove = append(remove, f)
+			}
+		}
+	case "move":
+		dst := c.destination(job, "")
+		for _, f := range files {
+			if err := moveFile(filepath.Join(job.Input, f), filepath.Join(dst, f)); err != nil {
+				return err
+			}
+		}
+		job.logf("moved %d frames to %s", len(files), dst)
+		return nil
+	case "zip":
+		dst := c.destination(job, ".zip")
+		if err := writeZip(dst, job.Input, files); err != nil {
+			return err
+		}
+		job.logf("archived %d frames to %s", len(files), dst)
+		remove = files
+	case "tar.zst":
+		dst := c.destination(job, ".tar.zst")
+		if err := writeTarZst(dst, job.Input, files); err != nil {
+			return err
+		}
+		job.logf("archived %d frames to %s", len(files), dst)
+		remove = files
+	default:
+		return fmt.Errorf("unknown cleanup action %q", c.action)
+	}
+	for _, f := range remove {
+		if err := os.Remove(filepath.Join(job.Input, f)); err != nil {
+			return err
+		}
+	}
+	job.logf("deleted %d frames", len(remove))
+	return nil
+}
+
+// moveFile renames src to dst, copying it if they are on different devices.
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// writeZip stores files from dir in a zip at dst. Frames are already
+// compressed, so they are stored as they are.
+func writeZip(dst, dir string, files []string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	z := zip.NewWriter(out)
+	for _, f := range files {
+		p := filepath.Join(dir, f)
+		st, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		h, err := zip.FileInfoHeader(st)
+		if err != nil {
+			return err
+		}
+		h.Name = filepath.ToSlash(f)
+		h.Method = zip.Store
+		w, err := z.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if err := copyFrom(w, p); err != nil {
+			return err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// writeTarZst writes files from dir to a tar at dst, compressed by the zstd
+// tool.
+func writeTarZst(dst, dir string, files []string) error {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		return fmt.Errorf("zstd was not found: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(zstd, "-q", "-f", "-o", dst)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	err = func() error {
+		defer stdin.Close()
+		t := tar.NewWriter(stdin)
+		for _, f := range files {
+			p := filepath.Join(dir, f)
+			st, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			h, err := tar.FileInfoHeader(st, "")
+			if err != nil {
+				return err
+			}
+			h.Name = filepath.ToSlash(f)
+			if err := t.WriteHeader(h); err != nil {
+				return err
+			}
+			if err := copyFrom(t, p); err != nil {
+				return err
+			}
+		}
+		return t.Close()
+	}()
+	if werr := cmd.Wait(); werr != nil && err == nil {
+		err = fmt.Errorf("%v\n%s", werr, stderr.String())
+	}
+	return err
+}
+
+func copyFrom(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (e *encoder) setupCleanup() *widget.AccordionItem {
+	p := a.Preferences()
+	c := &e.cleanup
+
+	archiveLabel := widget.NewLabel("Archive directory")
+	archiveInput := widget.NewEntry()
+	archiveInput.SetPlaceHolder("Beside the output")
+	archiveInput.SetText(p.String("cleanupArchive"))
+	c.archive = archiveInput.Text
+	archiveInput.OnChanged = func(s string) {
+		c.archive = s
+		p.SetString("cleanupArchive", s)
+	}
+	archiveOpen := dialog.NewFolderOpen(func(uri fyne.ListableURI, err error) {
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if uri == nil {
+			return
+		}
+		archiveInput.SetText(uri.Path())
+	}, window)
+	archiveOpen.SetConfirmText("Select")
+	archiveButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		archiveOpen.Show()
+	})
+
+	everyLabel := widget.NewLabel("Keep every")
+	c.every = p.IntWithFallback("cleanupEvery", 10)
+	everyInput := makeNumberEntry(c.every)
+	everyInput.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n >= 2 {
+			c.every = n
+			p.SetInt("cleanupEvery", n)
+		}
+	}
+
+	actionLabel := widget.NewLabel("After encoding")
+	actionCombo := widget.NewSelect(cleanupActions, func(value string) {
+		c.action = value
+		p.SetString("cleanupAction", value)
+		if value == "move" || value == "zip" || value == "tar.zst" {
+			archiveInput.Enable()
+		} else {
+			archiveInput.Disable()
+		}
+		if value == "thin" {
+			everyInput.Enable()
+		} else {
+			everyInput.Disable()
+		}
+	})
+	actionCombo.SetSelected(p.StringWithFallback("cleanupAction", "keep"))
+
+	return widget.NewAccordionItem("Frame cleanup", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), actionLabel), nil, actionCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), archiveLabel), nil,
+			container.NewBorder(nil, nil, nil, archiveButton, archiveInput),
+		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), everyLabel), nil, everyInput),
+		widget.NewLabel("Frames are only cleaned up once every output exists and decodes.\nYou are asked first before any frames are deleted."),
+	))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFrames returns n frames of size with a square moving across them.
+func testFrames(n int, size image.Point) []*image.RGBA {
+	frames := make([]*image.RGBA, n)
+	for i := range frames {
+		m := image.NewRGBA(image.Rectangle{Max: size})
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				m.SetRGBA(x, y, color.RGBA{uint8(x * 255 / size.X), uint8(y * 255 / size.Y), 96, 255})
+			}
+		}
+		for y := 4; y < 12; y++ {
+			for x := i * 4; x < i*4+8 && x < size.X; x++ {
+				m.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+			}
+		}
+		frames[i] = m
+	}
+	return frames
+}
+
+// writeTestOutput encodes frames to p with the integrated sink for its
+// extension.
+func writeTestOutput(t *testing.T, p string, frames []*image.RGBA, delays []float64) {
+	t.Helper()
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	canvas := frames[0].Rect
+	var e encoder
+	var sink frameSink
+	switch filepath.Ext(p) {
+	case ".gif":
+		e.gifPalette, e.gifDither = "global", "none"
+		s := e.newGIFSink(out)
+		for _, m := range frames {
+			s.analyze(m)
+		}
+		sink = s
+	case ".png":
+		sink, err = newAPNGSink(out, canvas, len(frames), false, 0)
+	case ".avi":
+		sink, err = newAVISink(out, canvas, delays, 90)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range frames {
+		if err := sink.add(m, delays[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyOutput(t *testing.T) {
+	dir := t.TempDir()
+	frames := testFrames(4, image.Pt(48, 32))
+	delays := []float64{0.1, 0.1, 0.1, 0.1}
+	for _, name := range []string{"out.gif", "out.png", "out.avi"} {
+		p := filepath.Join(dir, name)
+		writeTestOutput(t, p, frames, delays)
+		if err := verifyOutput(p); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		truncated := filepath.Join(dir, "truncated-"+name)
+		os.WriteFile(truncated, b[:len(b)*2/3], 0644)
+		if err := verifyOutput(truncated); err == nil {
+			t.Errorf("%s: truncated output verified", name)
+		}
+	}
+}
+
+func TestVerifyOutputCorruptFrame(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "out.avi")
+	writeTestOutput(t, p, testFrames(2, image.Pt(48, 32)), []float64{0.1, 0.1})
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the JPEG start of image marker of the first frame.
+	for i := 0; i+1 < len(b); i++ {
+		if b[i] == 0xff && b[i+1] == 0xd8 {
+			b[i+1] = 0
+			break
+		}
+	}
+	os.WriteFile(p, b, 0644)
+	if err := verifyOutput(p); err == nil {
+		t.Error("AVI with a corrupt frame verified")
+	}
+}
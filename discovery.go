@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	_ "image/gif"
+	_ "image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	_ "golang.org/x/image/bmp"
+)
+
+var frameSortModes = []string{"timestamp", "name", "modified"}
+
+var frameExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".bmp", ".qoi"}
+
+// frameDiscovery decides which files in a directory are frames and in what
+// order they are encoded.
+type frameDiscovery struct {
+	sort      string
+	include   []string
+	exclude   []string
+	recursive bool
+}
+
+func splitPatterns(s string) (patterns []string) {
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return
+}
+
+// matchAny reports whether the relative path or its base name matches any of
+// the glob patterns.
+func matchAny(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isFrameFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range frameExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// mixedFormats reports whether files use more than one image format.
+func mixedFormats(files []string) bool {
+	for _, f := range files {
+		if !strings.EqualFold(filepath.Ext(f), filepath.Ext(files[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// discover returns the frames in dir as paths relative to it, sorted.
+func (d frameDiscovery) discover(dir string) (files []string, err error) {
+	modTimes := make(map[string]time.Time)
+	err = filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p == dir {
+				return nil
+			}
+			if !d.recursive || strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if !isFrameFile(rel) {
+			return nil
+		}
+		if len(d.include) > 0 && !matchAny(d.include, rel) {
+			return nil
+		}
+		if matchAny(d.exclude, rel) {
+			return nil
+		}
+		if d.sort == "modified" {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			modTimes[rel] = info.ModTime()
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch d.sort {
+	case "modified":
+		sort.SliceStable(files, func(i, j int) bool {
+			if !modTimes[files[i]].Equal(modTimes[files[j]]) {
+				return modTimes[files[i]].Before(modTimes[files[j]])
+			}
+			return naturalLess(files[i], files[j])
+		})
+	case "name":
+		sort.SliceStable(files, func(i, j int) bool {
+			return naturalLess(files[i], files[j])
+		})
+	default:
+		// Frames named by timestamp come first, in capture order.
+		sort.SliceStable(files, func(i, j int) bool {
+			ti, iok := frameTimestamp(files[i])
+			tj, jok := frameTimestamp(files[j])
+			if iok && jok && !ti.Equal(tj) {
+				return ti.Before(tj)
+			} else if iok != jok {
+				return iok
+			}
+			return naturalLess(files[i], files[j])
+		})
+	}
+	return files, nil
+}
+
+// naturalLess compares strings so that runs of digits are ordered by value,
+// putting "frame2" before "frame10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ad, bd := digitPrefix(a), digitPrefix(b)
+		if ad > 0 && bd > 0 {
+			an := strings.TrimLeft(a[:ad], "0")
+			bn := strings.TrimLeft(b[:bd], "0")
+			if len(an) != len(bn) {
+				return len(an) < len(bn)
+			}
+			if an != bn {
+				return an < bn
+			}
+			a, b = a[ad:], b[bd:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+// frameTimestamp parses the unix millisecond timestamp gosh names frames with.
+func frameTimestamp(name string) (time.Time, bool) {
+	base := filepath.Base(name)
+	ms, err := strconv.ParseInt(strings.TrimSuffix(base, filepath.Ext(base)), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// frameTime returns the capture time encoded in a frame's name, falling back
+// to the file's modification time.
+func frameTime(dir, name string) time.Time {
+	if t, ok := frameTimestamp(name); ok {
+		return t
+	}
+	if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (e *encoder) setupDiscovery() *widget.AccordionItem {
+	p := a.Preferences()
+	d := &e.discovery
+
+	sortLabel := widget.NewLabel("Order by")
+	sortCombo := widget.NewSelect(frameSortModes, func(value string) {
+		d.sort = value
+		p.SetString("frameSort", value)
+	})
+	sortCombo.SetSelected(p.StringWithFallback("frameSort", "timestamp"))
+
+	includeLabel := widget.NewLabel("Include")
+	includeInput := widget.NewEntry()
+	includeInput.SetPlaceHolder("*.png, session-*/*")
+	includeInput.SetText(p.String("frameInclude"))
+	d.include = splitPatterns(includeInput.Text)
+	includeInput.OnChanged = func(s string) {
+		d.include = splitPatterns(s)
+		p.SetString("frameInclude", s)
+	}
+
+	excludeLabel := widget.NewLabel("Exclude")
+	excludeInput := widget.NewEntry()
+	excludeInput.SetPlaceHolder("thumb_*")
+	excludeInput.SetText(p.String("frameExclude"))
+	d.exclude = splitPatterns(excludeInput.Text)
+	excludeInput.OnChanged = func(s string) {
+		d.exclude = splitPatterns(s)
+		p.SetString("frameExclude", s)
+	}
+
+	recursiveLabel := widget.NewLabel("Subfolders")
+	recursiveCheck := widget.NewCheck("Include session subfolders", func(value bool) {
+		d.recursive = value
+		p.SetBool("frameRecursive", value)
+	})
+	recursiveCheck.SetChecked(p.Bool("frameRecursive"))
+
+	dryRunButton := widget.NewButton("List frames", func() {
+		e.showDryRun(e.inputDirInput.Text)
+	})
+
+	return widget.NewAccordionItem("Input", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), sortLabel), nil, sortCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), includeLabel), nil, includeInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), excludeLabel), nil, excludeInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), recursiveLabel), nil, recursiveCheck),
+		container.NewCenter(dryRunButton),
+	))
+}
+
+// showDryRun lists exactly which frames an encode of inpath would use.
+func (e *encoder) showDryRun(inpath string) {
+	files, err := e.listFrames(inpath)
+	if err != nil {
+		dialog.ShowError(err, window)
+		return
+	}
+	list := widget.NewList(
+		func() int {
+			return len(files)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("")
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(fmt.Sprintf("%d. %s  (%s)", id+1, files[id], frameTime(inpath, files[id]).Format("2006-01-02 15:04:05.000")))
+		},
+	)
+	d := dialog.NewCustom(fmt.Sprintf("%d frames", len(files)), "Close", container.NewMax(list), window)
+	d.Resize(fyne.NewSize(500, 400))
+	d.Show()
+}
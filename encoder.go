@@ -2,90 +2,125 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"image/png"
+	"image"
+	_ "image/png"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/kettek/apng"
-)
-
-type backend int
-
-const (
-	backendFFMPEG backend = iota
-	backendImageMagick
-	backendIntegrated
 )
 
 type encoder struct {
 	container *fyne.Container
 
-	typeCombo     *widget.Select
+	typeChecks    *widget.CheckGroup
 	fpsInput      *widget.Entry
 	inputDirInput *widget.Entry
 	outFileInput  *widget.Entry
 	toggleButton  *widget.Button
 	encodeInfo    *widget.TextGrid
+	gifOptions    *fyne.Container
+	apngOptions   *fyne.Container
+	aviOptions    *fyne.Container
+	options       *widget.Accordion
 
 	swapFFMPEGFramerate bool
 
-	backend    backend
+	gifPalette  string
+	gifDither   string
+	gifOptimize bool
+
+	apngOptimize bool
+
+	aviQuality int
+
+	geometry  frameGeometry
+	discovery frameDiscovery
+	timing    frameTiming
+	text      frameText
+
+	interpolation frameInterpolation
+	sheet         contactSheet
+	audio         backgroundAudio
+	loop          frameLoop
+	target        targetSize
+	segments      segmentation
+	cleanup       frameCleanup
+
+	// frames limits an encode to these frames, as one segment of those
+	// listed, when set.
+	frames []string
+	// selection holds the frames marked when a job was queued, in place of
+	// those marked in the browser now.
+	selection *frameSelection
+	// started is when the first frame of the encode was captured, segments
+	// included, which elapsed timestamps count from.
+	started time.Time
+	// fit holds the reductions of a re-encode to fit the target size.
+	fit sizeFit
+	// notes describes how each type of the running encode was fitted to the
+	// target size.
+	notes map[string]string
+
+	normalizeCombo  *widget.Select
+	normalizeMode   string
+	normalizeTarget image.Point
+
+	// progress receives status updates instead of encodeInfo when set.
+	progress func(string)
+	// commands collects the commands an encode would run, instead of
+	// running them, when set.
+	commands *[]previewedCommand
+	// editTemplate opens t in the custom template editor.
+	editTemplate func(t argTemplate)
+	encoding     bool
+
+	// backend is nil when each type is routed to a backend automatically.
+	backend    encoderBackend
 	outputPath string
 }
 
-func (e *encoder) setup(backend backend) {
+// setup builds the Encode tab for backend, or for automatic routing if nil.
+func (e *encoder) setup(backend encoderBackend) {
 	setup := false
 	e.backend = backend
 
 	e.swapFFMPEGFramerate = a.Preferences().BoolWithFallback("swapFFMPEGFramerate", false)
 
-	var types []string
-	switch backend {
-	case backendFFMPEG:
-		if aSettings.getFFMPEGPath() != "" {
-			types = append(types, "webm", "png", "gif", "mp4")
-		}
-	case backendImageMagick:
-		if aSettings.getConvertPath() != "" {
-			types = append(types, "gif")
-		}
-		if aSettings.getMagickPath() != "" {
-			types = append(types, "png")
-		}
-	case backendIntegrated:
-		types = append(types, "png")
-	}
+	types := e.types()
 
 	// Type
 	typeLabel := widget.NewLabel("Type")
-	e.typeCombo = widget.NewSelect(types, func(value string) {
-		a.Preferences().SetString("encoderType", e.typeCombo.Selected)
+	e.typeChecks = widget.NewCheckGroup(types, func(value []string) {
+		a.Preferences().SetString("encoderType", strings.Join(e.kinds(), ","))
 		if setup {
-			e.outFileInput.SetText(e.outputPath + "." + e.typeCombo.Selected)
+			e.outFileInput.SetText(outputName(e.outputPath, e.kinds()))
+			e.refreshOptions()
 		}
 	})
+	e.typeChecks.Horizontal = true
 
-	selected := false
-	for i, t := range types {
-		if t == a.Preferences().StringWithFallback("encoderType", "webm") {
-			e.typeCombo.SetSelectedIndex(i)
-			selected = true
-			break
+	var selected []string
+	for _, t := range strings.Split(a.Preferences().StringWithFallback("encoderType", "webm"), ",") {
+		if hasKind(types, t) {
+			selected = append(selected, t)
 		}
 	}
-	if !selected {
-		e.typeCombo.SetSelectedIndex(0)
+	if len(selected) == 0 && len(types) > 0 {
+		selected = types[:1]
 	}
+	e.typeChecks.SetSelected(selected)
 
 	// fps
 	fpsLabel := widget.NewLabel("FPS")
@@ -140,7 +175,7 @@ func (e *encoder) setup(backend backend) {
 	outFileLabel := widget.NewLabel("Output file")
 	e.outFileInput = widget.NewEntry()
 	e.outputPath = a.Preferences().String("encoderOutputFile")
-	e.outFileInput.SetText(a.Preferences().String("encoderOutputFile") + "." + e.typeCombo.Selected)
+	e.outFileInput.SetText(outputName(e.outputPath, e.kinds()))
 	e.outFileInput.Disable()
 	outFileSave := dialog.NewFileSave(func(uri fyne.URIWriteCloser, err error) {
 		if err != nil {
@@ -156,7 +191,7 @@ func (e *encoder) setup(backend backend) {
 		// Strip out the extension
 		e.outputPath = strings.TrimSuffix(e.outputPath, filepath.Ext(e.outputPath))
 		a.Preferences().SetString("encoderOutputFile", e.outputPath)
-		e.outFileInput.SetText(e.outputPath + "." + e.typeCombo.Selected)
+		e.outFileInput.SetText(outputName(e.outputPath, e.kinds()))
 	}, window)
 	outButton := widget.NewButton("", func() {
 		outFileSave.Show()
@@ -174,17 +209,86 @@ func (e *encoder) setup(backend backend) {
 	})
 	openButton.Icon = theme.MailForwardIcon()
 
+	// GIF
+	gifPaletteLabel := widget.NewLabel("GIF palette")
+	gifPaletteCombo := widget.NewSelect(gifPaletteModes, func(value string) {
+		e.gifPalette = value
+		a.Preferences().SetString("gifPalette", value)
+	})
+	gifPaletteCombo.SetSelected(a.Preferences().StringWithFallback("gifPalette", "global"))
+	gifDitherLabel := widget.NewLabel("GIF dithering")
+	gifDitherCombo := widget.NewSelect(gifDitherModes, func(value string) {
+		e.gifDither = value
+		a.Preferences().SetString("gifDither", value)
+	})
+	gifDitherCombo.SetSelected(a.Preferences().StringWithFallback("gifDither", "floyd-steinberg"))
+	gifOptimizeLabel := widget.NewLabel("GIF optimize")
+	gifOptimizeCheck := widget.NewCheck("Only store changed areas", func(value bool) {
+		e.gifOptimize = value
+		a.Preferences().SetBool("gifOptimize", value)
+	})
+	gifOptimizeCheck.SetChecked(a.Preferences().BoolWithFallback("gifOptimize", true))
+	e.gifOptions = container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), gifPaletteLabel), nil, gifPaletteCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), gifDitherLabel), nil, gifDitherCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), gifOptimizeLabel), nil, gifOptimizeCheck),
+	)
+
+	// APNG
+	apngOptimizeLabel := widget.NewLabel("APNG optimize")
+	apngOptimizeCheck := widget.NewCheck("Only store changed areas", func(value bool) {
+		e.apngOptimize = value
+		a.Preferences().SetBool("apngOptimize", value)
+	})
+	apngOptimizeCheck.SetChecked(a.Preferences().BoolWithFallback("apngOptimize", true))
+	e.apngOptions = container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), apngOptimizeLabel), nil, apngOptimizeCheck),
+	)
+
+	// AVI
+	aviQualityLabel := widget.NewLabel("AVI JPEG quality")
+	e.aviQuality = a.Preferences().IntWithFallback("aviQuality", 90)
+	aviQualityInput := makeNumberEntry(e.aviQuality)
+	aviQualityInput.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= 100 {
+			e.aviQuality = n
+			a.Preferences().SetInt("aviQuality", n)
+		}
+	}
+	e.aviOptions = container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), aviQualityLabel), nil, aviQualityInput),
+	)
+
 	e.toggleButton = widget.NewButton("", func() {
 		e.toggle()
 	})
 	e.toggleButton.Icon = theme.MediaPlayIcon()
+	queueButton := widget.NewButtonWithIcon("Add to queue", theme.ContentAddIcon(), func() {
+		aQueue.add(e.queuedJob())
+	})
 
 	e.encodeInfo = widget.NewTextGridFromString("...")
 
+	e.options = widget.NewAccordion(
+		e.setupDiscovery(),
+		e.setupGeometry(),
+		e.setupTiming(),
+		e.setupLoop(),
+		e.setupInterpolation(),
+		e.setupText(),
+		e.setupAudio(),
+		e.setupTarget(),
+		e.setupSegments(),
+		e.setupCleanup(),
+		e.setupSheet(),
+		e.setupTemplates(),
+		e.setupPreview(),
+	)
+
 	setup = true
 
 	e.container = container.NewVBox(
-		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), typeLabel), nil, e.typeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), typeLabel), nil, e.typeChecks),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), fpsLabel), nil, e.fpsInput),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), inputDirLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, inputDirFolderButton, inputDirOpenButton), e.inputDirInput),
@@ -192,120 +296,470 @@ func (e *encoder) setup(backend backend) {
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), outFileLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, outButton, openButton), e.outFileInput),
 		),
-		container.NewCenter(e.toggleButton),
+		e.gifOptions,
+		e.apngOptions,
+		e.aviOptions,
+		e.options,
+		container.NewCenter(container.NewHBox(e.toggleButton, queueButton)),
 		container.NewCenter(e.encodeInfo),
 	)
+	e.refreshOptions()
 }
 
-func (e *encoder) toggle() {
-	inpath := e.inputDirInput.Text
-	outpath := e.outputPath
-	kind := e.typeCombo.Selected
-
-	e.encodeTo(inpath, outpath, kind)
+// types returns the output types the backend, or every available backend,
+// can write, followed by the custom templates.
+func (e *encoder) types() (types []string) {
+	if e.backend != nil {
+		types = e.backend.formats()
+	} else {
+		for _, b := range autoOrder() {
+			if !b.available() {
+				continue
+			}
+			for _, t := range b.formats() {
+				if !hasKind(types, t) {
+					types = append(types, t)
+				}
+			}
+		}
+	}
+	return append(types, templateBackend{}.formats()...)
 }
 
-func (e *encoder) encodeTo(inpath, outpath, kind string) {
-	e.toggleButton.Icon = theme.MediaStopIcon()
-	var args []string
-	files, err := getPNGs(inpath)
-	if err != nil {
-		e.encodeInfo.SetText(err.Error())
-		e.toggleButton.Icon = theme.MediaPlayIcon()
-		return
+// refreshTypes offers the current output types, keeping those selected.
+func (e *encoder) refreshTypes() {
+	selected := e.kinds()
+	e.typeChecks.Options = e.types()
+	var kept []string
+	for _, t := range selected {
+		if hasKind(e.typeChecks.Options, t) {
+			kept = append(kept, t)
+		}
 	}
+	e.typeChecks.SetSelected(kept)
+	e.typeChecks.Refresh()
+}
 
-	switch e.backend {
-	case backendFFMPEG:
-		args = append(args, "-y")
-
-		if e.swapFFMPEGFramerate {
-			args = append(args, "-framerate", e.fpsInput.Text)
+// refreshOptions shows the options relevant to the current backend and type.
+func (e *encoder) refreshOptions() {
+	integrated := func(kind string) bool {
+		if !hasKind(e.kinds(), kind) {
+			return false
 		}
+		route := e.route(kind)
+		if len(route) == 0 {
+			return false
+		}
+		_, ok := route[0].(integratedBackend)
+		return ok
+	}
+	if integrated("gif") {
+		e.gifOptions.Show()
+	} else {
+		e.gifOptions.Hide()
+	}
+	if integrated("png") {
+		e.apngOptions.Show()
+	} else {
+		e.apngOptions.Hide()
+	}
+	if integrated("avi") {
+		e.aviOptions.Show()
+	} else {
+		e.aviOptions.Hide()
+	}
+}
 
-		args = append(args, "-i", "concat:"+strings.Join(files, "|"))
-
-		if kind == "webm" {
-			args = append(args, "-c:v", "libvpx")
-			args = append(args, "-b:v", "2M")
-			args = append(args, "-crf", "10")
-			args = append(args, "-f", "webm")
-		} else if kind == "gif" {
-			args = append(args, "-filter_complex", "split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse")
-			args = append(args, "-f", "gif")
-		} else if kind == "mp4" {
-			args = append(args, "-c:v", "libx264")
-			args = append(args, "-crf", "0")
-			args = append(args, "-preset", "veryslow")
-			args = append(args, "-f", "mp4")
-		} else if kind == "png" {
-			args = append(args, "-f", "apng")
+// kinds returns the selected output types in the order they are offered.
+func (e *encoder) kinds() (kinds []string) {
+	for _, t := range e.typeChecks.Options {
+		if hasKind(e.typeChecks.Selected, t) {
+			kinds = append(kinds, t)
 		}
+	}
+	return
+}
 
-		if !e.swapFFMPEGFramerate {
-			args = append(args, "-framerate", e.fpsInput.Text)
+func hasKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
 		}
+	}
+	return false
+}
 
-		args = append(args, outpath+"."+kind)
+// kindExtensions holds the extensions of output types that are not named
+// after their extension, such as codecs sharing a container.
+var kindExtensions = map[string]string{
+	"av1":    "av1.mp4",
+	"hevc":   "hevc.mp4",
+	"prores": "mov",
+}
 
-		fmt.Println(args)
+// outputFile returns the file an encode to path writes for kind.
+func outputFile(path, kind string) string {
+	if ext, ok := kindExtensions[kind]; ok {
+		return path + "." + ext
+	}
+	if t := findTemplate(kind); t != nil {
+		return path + "." + t.Name + "." + t.Ext
+	}
+	return path + "." + kind
+}
 
-		e.runCmd(aSettings.getFFMPEGPath(), inpath, args)
-	case backendImageMagick:
-		cmdPath := aSettings.getConvertPath()
-		fr, _ := strconv.ParseFloat(e.fpsInput.Text, 64)
+// outputName shows the file, or files, an encode to path will produce.
+func outputName(path string, kinds []string) string {
+	if len(kinds) == 1 {
+		return outputFile(path, kinds[0])
+	}
+	exts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		exts[i] = strings.TrimPrefix(outputFile("", kind), ".")
+	}
+	return path + ".{" + strings.Join(exts, ",") + "}"
+}
+
+// currentJob describes an encode using the values entered in the Encode tab.
+func (e *encoder) currentJob() *encodeJob {
+	fps, _ := strconv.ParseFloat(e.fpsInput.Text, 64)
+	return &encodeJob{
+		Input:   e.inputDirInput.Text,
+		Output:  e.outputPath,
+		Kind:    strings.Join(e.kinds(), ","),
+		FPS:     fps,
+		Backend: e.backendName(),
+		Status:  jobQueued,
+	}
+}
+
+// queuedJob returns the current job along with a copy of the settings and
+// frame selection, so changes made while it waits in the queue leave it be.
+func (e *encoder) queuedJob() *encodeJob {
+	job := e.currentJob()
+	settings := *e
+	settings.selection = aFrameBrowser.selection()
+	job.settings = &settings
+	return job
+}
 
-		// convert fps to imagemagick delay:
-		args = append(args, "-delay", strconv.Itoa(int(100/fr)))
+func (e *encoder) toggle() {
+	if e.encoding {
+		return
+	}
+	job := e.currentJob()
+	if job.Kind == "" {
+		e.encodeInfo.SetText("no output type selected")
+		return
+	}
 
-		args = append(args, "-loop", "0")
-		args = append(args, files...)
+	files, err := e.listFrames(job.Input)
+	if err != nil {
+		e.encodeInfo.SetText(err.Error())
+		return
+	}
+	sizes, err := scanFrameSizes(job.Input, files)
+	if err != nil {
+		e.encodeInfo.SetText(err.Error())
+		return
+	}
+	if sizes.mixed() {
+		e.confirmNormalize(sizes, func() {
+			e.start(job)
+		})
+		return
+	}
+	e.start(job)
+}
 
-		if kind == "gif" {
-			args = append(args, outpath+"."+kind)
-		} else if kind == "png" {
-			cmdPath = aSettings.getMagickPath()
-			args = append(args, "APNG:"+outpath+"."+kind)
+// start runs job in the background, reporting to the Encode tab. The button
+// is disabled until it is done, as encodes cannot be stopped.
+func (e *encoder) start(job *encodeJob) {
+	e.encoding = true
+	button := e.toggleButton
+	button.Disable()
+	// Settings rebuilds aEncoder when changed, so the encode runs on a copy
+	// as queued jobs do.
+	run := *e
+	go func() {
+		if err := run.encodeTo(job); err != nil {
+			run.encodeInfo.SetText(err.Error())
+		} else {
+			run.cleanupFrames(job)
 		}
+		e.encoding = false
+		button.Enable()
+	}()
+}
+
+// report shows progress of the running encode.
+func (e *encoder) report(s string) {
+	if e.progress != nil {
+		e.progress(s)
+	} else if e.encodeInfo != nil {
+		e.encodeInfo.SetText(s)
+	}
+}
+
+// listFrames returns the frames in inpath that should be encoded.
+func (e *encoder) listFrames(inpath string) ([]string, error) {
+	if e.frames != nil {
+		return e.frames, nil
+	}
+	files, err := e.discovery.discover(inpath)
+	if err != nil {
+		return nil, err
+	}
+	if e.selection != nil {
+		files = e.selection.filter(inpath, files)
+	} else {
+		files = aFrameBrowser.filter(inpath, files)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	return files, nil
+}
 
-		e.runCmd(cmdPath, inpath, args)
-	case backendIntegrated:
-		e.encodeInfo.SetText("processing...")
-		fr, _ := strconv.ParseFloat(e.fpsInput.Text, 64)
-		a := apng.APNG{
-			Frames: make([]apng.Frame, len(files)),
+func (e *encoder) backendName() string {
+	if e.backend == nil {
+		return "auto"
+	}
+	return e.backend.name()
+}
+
+// route returns the backends that may write kind, in the order to try them.
+func (e *encoder) route(kind string) (route []encoderBackend) {
+	if findTemplate(kind) != nil {
+		return []encoderBackend{templateBackend{}}
+	}
+	if e.backend != nil {
+		return []encoderBackend{e.backend}
+	}
+	for _, b := range autoOrder() {
+		if b.available() && hasKind(b.formats(), kind) {
+			route = append(route, b)
 		}
-		out, err := os.Create(outpath + "." + kind)
+	}
+	return
+}
+
+// encodeTo encodes the frames described by job, returning once it is done.
+// Types that share a backend are encoded together, and types whose backend
+// fails are retried with the next backend able to write them.
+func (e *encoder) encodeTo(job *encodeJob) error {
+	kinds := job.kinds()
+	if len(kinds) == 0 {
+		return fmt.Errorf("no output type selected")
+	}
+	if e.frames == nil {
+		// The frames are listed once, so those captured or selected while
+		// encoding are neither encoded nor cleaned up afterwards.
+		files, err := e.listFrames(job.Input)
 		if err != nil {
-			e.encodeInfo.SetText(err.Error())
-			return
+			return err
+		}
+		job.setFrames(files)
+		run := *e
+		run.frames = files
+		run.started = frameTime(job.Input, files[0])
+		if run.segments.active() {
+			return run.encodeSegments(job)
+		}
+		return run.encodeTo(job)
+	}
+	routes := make(map[string][]encoderBackend, len(kinds))
+	for _, kind := range kinds {
+		if routes[kind] = e.route(kind); len(routes[kind]) == 0 {
+			return fmt.Errorf("no backend can write %s", kind)
+		}
+	}
+
+	produced := make(map[string]string, len(kinds))
+	e.notes = make(map[string]string)
+	summarize := func() string {
+		var summary []string
+		for _, kind := range kinds {
+			name, ok := produced[kind]
+			if !ok {
+				continue
+			}
+			// Backends may note the size themselves, as the integrated
+			// backend does along with what optimizing saved.
+			var details []string
+			if st, err := os.Stat(outputFile(job.Output, kind)); err == nil && e.target.active() && e.commands == nil && !strings.Contains(e.notes[kind], " MB") {
+				details = append(details, fmt.Sprintf("%.2f MB", float64(st.Size())/1024/1024))
+			}
+			if e.notes[kind] != "" {
+				details = append(details, e.notes[kind])
+			}
+			if len(details) > 0 {
+				name += " (" + strings.Join(details, ", ") + ")"
+			}
+			summary = append(summary, kind+" by "+name)
 		}
-		defer out.Close()
-		for i, s := range files {
-			in, err := os.Open(filepath.Join(inpath, s))
-			if err != nil {
-				e.encodeInfo.SetText(err.Error())
-				return
+		s := strings.Join(summary, ", ")
+		job.setProduced(s)
+		return s
+	}
+	// failed holds the types no backend could write, which are given up on
+	// while the rest carry on.
+	failed := make(map[string]error)
+	for len(produced)+len(failed) < len(kinds) {
+		// Take the best remaining backend of the first unwritten type,
+		// along with every other type it is the best remaining one for.
+		var b encoderBackend
+		var group []string
+		for _, kind := range kinds {
+			if _, ok := produced[kind]; ok {
+				continue
+			}
+			if _, ok := failed[kind]; ok {
+				continue
+			}
+			if b == nil {
+				b = routes[kind][0]
 			}
-			defer in.Close()
-			m, err := png.Decode(in)
-			if err != nil {
-				e.encodeInfo.SetText(err.Error())
-				return
+			if routes[kind][0] == b {
+				group = append(group, kind)
 			}
-			a.Frames[i].Image = m
-			a.Frames[i].DelayDenominator = 100
-			a.Frames[i].DelayNumerator = uint16(100 / fr)
 		}
-		if err := apng.Encode(out, a); err != nil {
-			e.encodeInfo.SetText(err.Error())
+
+		err := e.encodeWith(b, job, group)
+		if err == nil {
+			for _, kind := range group {
+				if e.target.active() && e.commands == nil && !aimsAtSize(b, kind) {
+					if err := e.fitSize(b, job, kind); err != nil {
+						summarize()
+						return err
+					}
+				}
+				produced[kind] = b.name()
+				if e.commands == nil {
+					job.addOutputs(outputFile(job.Output, kind))
+				}
+				job.logf("%s written by %s", outputFile(job.Output, kind), b.name())
+			}
+			continue
+		}
+		job.logf("%s failed: %s", b.name(), err)
+		for _, kind := range group {
+			if routes[kind] = routes[kind][1:]; len(routes[kind]) == 0 {
+				failed[kind] = fmt.Errorf("%s: %s: %w", kind, b.name(), err)
+				continue
+			}
+			e.report(fmt.Sprintf("%s failed, retrying %s with %s", b.name(), kind, routes[kind][0].name()))
 		}
-		e.encodeInfo.SetText("complete")
 	}
-	e.toggleButton.Icon = theme.MediaPlayIcon()
+
+	if len(failed) > 0 {
+		summarize()
+		var errs []error
+		for _, kind := range kinds {
+			if err, ok := failed[kind]; ok {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	e.report("complete: " + summarize())
+	return nil
+}
+
+// encodeWith encodes the kinds of job with the backend b.
+func (e *encoder) encodeWith(b encoderBackend, job *encodeJob, kinds []string) error {
+	inpath := job.Input
+	files, err := e.listFrames(inpath)
+	if err != nil {
+		return err
+	}
+	numbers := make(map[string]int, len(files))
+	for i, f := range files {
+		numbers[f] = i + 1
+	}
+	files, delays, err := e.timing.schedule(inpath, files, job.FPS)
+	if err != nil {
+		return err
+	}
+	files, delays = e.fit.decimate(files, delays)
+	files, delays = e.loop.apply(files, delays)
+	sizes, err := scanFrameSizes(inpath, files)
+	if err != nil {
+		return err
+	}
+	e.normalizeTarget = image.Point{}
+	base := sizes.first
+	if sizes.mixed() {
+		e.normalizeTarget = sizes.target(e.normalizeMode)
+		base = e.normalizeTarget
+	}
+	layout := e.geometry.layout(base.X, base.Y)
+	canvas := image.Rectangle{Max: layout.size}
+
+	frameDir, frames := inpath, files
+	load := func(i int) (*image.RGBA, error) {
+		return e.loadFrame(filepath.Join(frameDir, frames[i]))
+	}
+
+	// ffmpeg can interpolate by itself, but only at a constant rate.
+	_, uniform := uniformRate(delays)
+	ib, ok := b.(interpolatingBackend)
+	backendInterpolate := e.interpolation.active() && e.interpolation.method != "blend" && ok && ib.interpolates() && uniform && !e.text.active()
+	var interpolated []interpolatedFrame
+	if e.interpolation.active() && !backendInterpolate {
+		delays, interpolated, load = e.interpolation.wrap(delays, load)
+	}
+
+	if e.text.active() {
+		textFrames := make([]textFrame, len(delays))
+		for i := range textFrames {
+			src, t := i, 0.0
+			if interpolated != nil {
+				src, t = interpolated[i].index, interpolated[i].t
+			}
+			textFrames[i].number = numbers[files[src]]
+			if e.text.hasStamp() {
+				textFrames[i].captured = frameTime(inpath, files[src])
+				if t > 0 {
+					next := frameTime(inpath, files[src+1])
+					textFrames[i].captured = textFrames[i].captured.Add(time.Duration(t * float64(next.Sub(textFrames[i].captured))))
+				}
+			}
+		}
+		delays, load = e.text.wrap(layout.size, textFrames, e.started, delays, load)
+	}
+	fr, uniform := uniformRate(delays)
+
+	rendered := e.text.active() || interpolated != nil
+	if fb, ok := b.(fileBackend); ok && (sizes.mixed() || rendered || (!fb.mixedFormats() && mixedFormats(files)) || (!fb.layouts() && !layout.identity(base.X, base.Y))) {
+		// External tools cannot mix sizes or draw what gosh generates, and
+		// some cannot read differing formats in one run or apply the
+		// geometry, so hand them rendered copies.
+		dir, staged, err := e.stageFrames(len(delays), load)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		inpath, files = dir, staged
+		base = layout.size
+		layout = frameGeometry{}.layout(base.X, base.Y)
+	}
+
+	return b.encode(e, job, kinds, &encodeFrames{
+		dir:         inpath,
+		files:       files,
+		base:        base,
+		layout:      layout,
+		load:        load,
+		canvas:      canvas,
+		delays:      delays,
+		fps:         fr,
+		uniform:     uniform,
+		interpolate: backendInterpolate,
+	})
 }
 
-func (e *encoder) runCmd(binPath string, cwd string, args []string) {
+func (e *encoder) runCmd(job *encodeJob, binPath string, cwd string, args []string) error {
 	cmd := exec.Command(binPath, args...)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -313,36 +767,54 @@ func (e *encoder) runCmd(binPath string, cwd string, args []string) {
 	cmd.Stderr = &stderr
 	cmd.Dir, _ = filepath.Abs(cwd)
 
-	e.encodeInfo.SetText("processing...")
+	if e.commands != nil {
+		*e.commands = append(*e.commands, previewedCommand{dir: cmd.Dir, bin: binPath, args: args})
+		return nil
+	}
+	job.logf("%s", formatCommand(binPath, args))
+	e.report("processing...")
 	if err := cmd.Start(); err != nil {
-		e.encodeInfo.SetText(err.Error())
-		return
+		return err
 	} else {
 		if err := cmd.Wait(); err != nil {
-			fmt.Println(stderr.String())
-			e.encodeInfo.SetText(err.Error())
-			return
+			job.logf("%s", stderr.String())
+			return err
 		}
 	}
-	e.encodeInfo.SetText("complete")
+	e.report("complete")
+	return nil
 }
 
-func getPNGs(p string) (files []string, err error) {
-	d, err := os.ReadDir(p)
+func decodeImage(p string) (image.Image, error) {
+	f, err := os.Open(p)
 	if err != nil {
-		return files, err
+		return nil, err
 	}
+	defer f.Close()
+	m, _, err := image.Decode(f)
+	return m, err
+}
 
-	for _, e := range d {
-		if e.IsDir() {
-			continue
-		}
-		if e.Name()[0] == 's' {
-			continue
-		}
-		if strings.HasSuffix(e.Name(), ".png") {
-			files = append(files, e.Name())
-		}
+// loadFrame decodes the frame at p and applies the encoder's geometry.
+func (e *encoder) loadFrame(p string) (*image.RGBA, error) {
+	m, err := decodeImage(p)
+	if err != nil {
+		return nil, err
 	}
-	return
+	rgba := toRGBA(m)
+	if e.normalizeTarget != (image.Point{}) && rgba.Rect.Size() != e.normalizeTarget {
+		g := normalizeGeometry(e.normalizeMode, e.normalizeTarget, e.geometry.padColor)
+		rgba = g.layout(rgba.Rect.Dx(), rgba.Rect.Dy()).apply(rgba, e.geometry.padColor)
+	}
+	return e.geometry.layout(rgba.Rect.Dx(), rgba.Rect.Dy()).apply(rgba, e.geometry.padColor), nil
+}
+
+func decodeImageConfig(p string) (image.Config, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	return cfg, err
 }
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerBackend(ffmpegBackend{})
+}
+
+// ffmpegFormat is an output type of the ffmpeg backend.
+type ffmpegFormat struct {
+	kind  string
+	muxer string
+	// codecs are tried in order, using the first the local build has.
+	codecs []ffmpegCodec
+	// basic formats are offered without probing, should it fail.
+	basic bool
+	// audio lists the codecs for background audio, for containers that
+	// hold it.
+	audio []string
+}
+
+type ffmpegCodec struct {
+	encoder string
+	args    []string
+}
+
+var ffmpegFormats = []ffmpegFormat{
+	{"webm", "webm", []ffmpegCodec{
+		{"libvpx", []string{"-b:v", "2M", "-crf", "10"}},
+		{"libvpx-vp9", []string{"-b:v", "2M", "-crf", "10"}},
+	}, true, []string{"libopus", "libvorbis"}},
+	{"png", "apng", []ffmpegCodec{{"apng", nil}}, true, nil},
+	{"gif", "gif", []ffmpegCodec{{"gif", nil}}, true, nil},
+	{"mp4", "mp4", []ffmpegCodec{
+		{"libx264", []string{"-crf", "0", "-preset", "veryslow"}},
+	}, true, []string{"aac"}},
+	{"webp", "webp", []ffmpegCodec{
+		{"libwebp_anim", []string{"-quality", "90"}},
+		{"libwebp", []string{"-quality", "90"}},
+	}, false, nil},
+	{"av1", "mp4", []ffmpegCodec{
+		{"libsvtav1", []string{"-crf", "30", "-preset", "8", "-pix_fmt", "yuv420p"}},
+		{"libaom-av1", []string{"-crf", "30", "-b:v", "0", "-cpu-used", "6", "-row-mt", "1", "-pix_fmt", "yuv420p"}},
+		{"librav1e", []string{"-qp", "80", "-pix_fmt", "yuv420p"}},
+	}, false, []string{"aac"}},
+	{"hevc", "mp4", []ffmpegCodec{
+		{"libx265", []string{"-crf", "23", "-preset", "medium", "-tag:v", "hvc1", "-pix_fmt", "yuv420p"}},
+	}, false, []string{"aac"}},
+	{"prores", "mov", []ffmpegCodec{
+		{"prores_ks", []string{"-profile:v", "3", "-pix_fmt", "yuv422p10le"}},
+		{"prores", []string{"-profile:v", "3", "-pix_fmt", "yuv422p10le"}},
+	}, false, []string{"pcm_s16le"}},
+}
+
+func findFFMPEGFormat(kind string) *ffmpegFormat {
+	for i := range ffmpegFormats {
+		if ffmpegFormats[i].kind == kind {
+			return &ffmpegFormats[i]
+		}
+	}
+	return nil
+}
+
+// codec returns the codec to write f with, or nil if the probed ffmpeg
+// cannot.
+func (f *ffmpegFormat) codec(caps toolCapabilities) *ffmpegCodec {
+	if !caps.probed() {
+		if f.basic && caps.path != "" {
+			return &f.codecs[0]
+		}
+		return nil
+	}
+	if !caps.muxers[f.muxer] {
+		return nil
+	}
+	for i, c := range f.codecs {
+		if caps.encoders[c.encoder] {
+			return &f.codecs[i]
+		}
+	}
+	return nil
+}
+
+// audioCodec returns the codec to write background audio into f with, or ""
+// if f cannot hold audio or the probed ffmpeg has none of its codecs.
+func (f *ffmpegFormat) audioCodec(caps toolCapabilities) string {
+	for _, c := range f.audio {
+		if !caps.probed() || caps.encoders[c] {
+			return c
+		}
+	}
+	return ""
+}
+
+// describeFFMPEG lists the formats caps allows and the codec each uses.
+func describeFFMPEG(caps toolCapabilities) string {
+	var formats []string
+	for i := range ffmpegFormats {
+		if c := ffmpegFormats[i].codec(caps); c != nil {
+			formats = append(formats, fmt.Sprintf("%s (%s)", ffmpegFormats[i].kind, c.encoder))
+		}
+	}
+	if len(formats) == 0 {
+		return caps.String()
+	}
+	return caps.String() + "\n" + strings.Join(formats, ", ")
+}
+
+// ffmpegBackend encodes through an ffmpeg process.
+type ffmpegBackend struct{}
+
+func (ffmpegBackend) name() string {
+	return "ffmpeg"
+}
+
+func (b ffmpegBackend) available() bool {
+	return len(b.formats()) > 0
+}
+
+func (ffmpegBackend) formats() (types []string) {
+	for i := range ffmpegFormats {
+		if ffmpegFormats[i].codec(aSettings.ffmpegCaps) != nil {
+			types = append(types, ffmpegFormats[i].kind)
+		}
+	}
+	return
+}
+
+// ffmpeg cannot concatenate differing formats.
+func (ffmpegBackend) mixedFormats() bool {
+	return false
+}
+
+func (ffmpegBackend) layouts() bool {
+	return true
+}
+
+func (ffmpegBackend) interpolates() bool {
+	return true
+}
+
+// ffmpegBitrateCodecs holds the arguments codecs take instead of their usual
+// ones when encoding to a bitrate, and whether they support two passes.
+var ffmpegBitrateCodecs = map[string]struct {
+	args    []string
+	twoPass bool
+}{
+	"libvpx":     {nil, true},
+	"libvpx-vp9": {nil, true},
+	"libx264":    {[]string{"-preset", "slow"}, true},
+	"libsvtav1":  {[]string{"-preset", "8", "-pix_fmt", "yuv420p"}, false},
+	"libaom-av1": {[]string{"-cpu-used", "6", "-row-mt", "1", "-pix_fmt", "yuv420p"}, true},
+	"librav1e":   {[]string{"-pix_fmt", "yuv420p"}, false},
+	"libx265":    {[]string{"-preset", "medium", "-tag:v", "hvc1", "-pix_fmt", "yuv420p"}, false},
+}
+
+// ffmpegOutput describes how one output of an ffmpeg run is written.
+type ffmpegOutput struct {
+	kind   string
+	filter string
+	// bitrate, in bits per second, replaces the codec's quality settings
+	// when set.
+	bitrate int
+	// pass is the pass of a two pass encode, or 0.
+	pass    int
+	passLog string
+}
+
+// aimsAtSize reports whether kind is encoded with a codec that has a bitrate
+// mode. Others, such as ProRes, are left to be re-encoded smaller.
+func (ffmpegBackend) aimsAtSize(kind string) bool {
+	format := findFFMPEGFormat(kind)
+	if format == nil {
+		return false
+	}
+	codec := format.codec(aSettings.ffmpegCaps)
+	if codec == nil {
+		return false
+	}
+	_, ok := ffmpegBitrateCodecs[codec.encoder]
+	return ok
+}
+
+func (ffmpegBackend) encode(e *encoder, job *encodeJob, kinds []string, f *encodeFrames) error {
+	input := []string{"-y"}
+	if f.uniform {
+		if e.swapFFMPEGFramerate {
+			input = append(input, "-framerate", strconv.FormatFloat(f.fps, 'f', -1, 64))
+		}
+		input = append(input, "-i", "concat:"+strings.Join(f.files, "|"))
+	} else {
+		// Frames shown for differing times go through the concat
+		// demuxer, which takes a duration for each.
+		list, remove, err := e.concatList(f)
+		if err != nil {
+			return err
+		}
+		defer remove()
+		input = append(input, "-f", "concat", "-safe", "0", "-i", list, "-vsync", "vfr")
+	}
+	if e.audio.active() {
+		input = append(input, e.audio.ffmpegInput()...)
+	}
+
+	withInput := func(args []string) []string {
+		return append(append([]string{}, input...), args...)
+	}
+
+	filter := f.layout.ffmpegFilter(f.base.X, f.base.Y, e.geometry.padColor)
+	if f.interpolate {
+		if filter != "" {
+			filter += ","
+		}
+		filter += e.interpolation.ffmpegFilter(f.fps)
+	}
+
+	// Every type is a separate output of the same process, so the frames
+	// are only decoded once, apart from video aimed at a target size,
+	// which takes a run of its own for each pass.
+	var args []string
+	var sized []string
+	for _, kind := range kinds {
+		format := findFFMPEGFormat(kind)
+		if format == nil {
+			return fmt.Errorf("%s is not supported by the ffmpeg backend", kind)
+		}
+		codec := format.codec(aSettings.ffmpegCaps)
+		if codec == nil {
+			return fmt.Errorf("%s is not supported by %s", kind, aSettings.getFFMPEGPath())
+		}
+		if e.target.active() && (ffmpegBackend{}).aimsAtSize(kind) {
+			sized = append(sized, kind)
+			continue
+		}
+		args = append(args, e.ffmpegOutputArgs(job, f, ffmpegOutput{kind: kind, filter: filter})...)
+	}
+	if len(args) > 0 {
+		if err := e.runCmd(job, aSettings.getFFMPEGPath(), f.dir, withInput(args)); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range sized {
+		format := findFFMPEGFormat(kind)
+		codec := format.codec(aSettings.ffmpegCaps)
+		out := ffmpegOutput{kind: kind, filter: filter}
+		hasAudio := e.audio.active() && format.audioCodec(aSettings.ffmpegCaps) != ""
+		out.bitrate = e.target.videoBitrate(totalDuration(f.delays), hasAudio)
+		if !ffmpegBitrateCodecs[codec.encoder].twoPass {
+			if err := e.runCmd(job, aSettings.getFFMPEGPath(), f.dir, withInput(e.ffmpegOutputArgs(job, f, out))); err != nil {
+				return err
+			}
+			e.notes[kind] = fmt.Sprintf("%d kb/s", out.bitrate/1000)
+			continue
+		}
+		dir, err := os.MkdirTemp("", "gosh-pass-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		out.passLog = filepath.Join(dir, kind)
+		for out.pass = 1; out.pass <= 2; out.pass++ {
+			if err := e.runCmd(job, aSettings.getFFMPEGPath(), f.dir, withInput(e.ffmpegOutputArgs(job, f, out))); err != nil {
+				return err
+			}
+		}
+		e.notes[kind] = fmt.Sprintf("%d kb/s in two passes", out.bitrate/1000)
+	}
+	return nil
+}
+
+// ffmpegOutputArgs returns the arguments that write one output.
+func (e *encoder) ffmpegOutputArgs(job *encodeJob, f *encodeFrames, out ffmpegOutput) (args []string) {
+	format := findFFMPEGFormat(out.kind)
+	codec := format.codec(aSettings.ffmpegCaps)
+
+	if out.kind == "gif" {
+		palette := "palettegen"
+		if e.fit.colors > 0 {
+			palette += "=max_colors=" + strconv.Itoa(e.fit.colors)
+		}
+		gifFilter := "split[s0][s1];[s0]" + palette + "[p];[s1][p]paletteuse"
+		if out.filter != "" {
+			gifFilter = out.filter + "," + gifFilter
+		}
+		args = append(args, "-vf", gifFilter)
+	} else if out.filter != "" {
+		args = append(args, "-vf", out.filter)
+	}
+
+	args = append(args, "-c:v", codec.encoder)
+	if out.bitrate > 0 {
+		args = append(args, ffmpegBitrateCodecs[codec.encoder].args...)
+		args = append(args, "-b:v", strconv.Itoa(out.bitrate))
+	} else {
+		args = append(args, codec.args...)
+	}
+	if out.pass > 0 {
+		args = append(args, "-pass", strconv.Itoa(out.pass), "-passlogfile", out.passLog)
+	}
+	if out.pass == 1 {
+		// The first pass only gathers statistics.
+		return append(args, "-an", "-f", "null", os.DevNull)
+	}
+
+	if e.audio.active() {
+		args = append(args, "-map", "0:v")
+		if audioCodec := format.audioCodec(aSettings.ffmpegCaps); audioCodec != "" {
+			args = append(args, "-map", "1:a")
+			args = append(args, "-c:a", audioCodec)
+			if out.bitrate > 0 {
+				args = append(args, "-b:a", strconv.Itoa(targetAudioBitrate))
+			}
+			args = append(args, "-af", e.audio.ffmpegFilter(totalDuration(f.delays)))
+		} else if format.audio != nil {
+			job.logf("%s: no audio encoder found, writing video only", out.kind)
+		}
+	}
+	switch format.muxer {
+	case "gif":
+		args = append(args, "-loop", strconv.Itoa(e.loop.gifLoopCount()))
+	case "apng":
+		args = append(args, "-plays", strconv.Itoa(e.loop.plays))
+	case "webp":
+		args = append(args, "-loop", strconv.Itoa(e.loop.plays))
+	}
+	args = append(args, "-f", format.muxer)
+
+	if f.uniform && !e.swapFFMPEGFramerate {
+		args = append(args, "-framerate", strconv.FormatFloat(f.fps, 'f', -1, 64))
+	}
+
+	return append(args, outputFile(job.Output, out.kind))
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/image/draw"
+)
+
+var scaleModes = []string{"none", "fit", "percent"}
+
+// frameGeometry describes how frames are cropped, scaled and padded before
+// they are encoded.
+type frameGeometry struct {
+	cropX, cropY, cropW, cropH int
+	scaleMode                  string
+	width, height              int
+	percent                    float64
+	pad                        bool
+	padColor                   color.RGBA
+	even                       bool
+	// upscale lets "fit" enlarge frames smaller than the size.
+	upscale bool
+	// shrink scales the result down further, to fit a target size.
+	shrink float64
+}
+
+// frameLayout is a frameGeometry resolved against a particular source size.
+type frameLayout struct {
+	crop   image.Rectangle
+	scaled image.Point
+	size   image.Point
+	offset image.Point
+}
+
+func evenDown(v int) int {
+	v -= v % 2
+	if v < 2 {
+		return 2
+	}
+	return v
+}
+
+// evenCrop rounds v down to even, unless that would leave nothing.
+func evenCrop(v int) int {
+	if v > 1 {
+		return v - v%2
+	}
+	return v
+}
+
+func (g frameGeometry) layout(w, h int) frameLayout {
+	var l frameLayout
+	l.crop = image.Rect(0, 0, w, h)
+	if g.cropW > 0 && g.cropH > 0 {
+		if r := image.Rect(g.cropX, g.cropY, g.cropX+g.cropW, g.cropY+g.cropH).Intersect(l.crop); !r.Empty() {
+			l.crop = r
+		}
+	}
+
+	sw, sh := float64(l.crop.Dx()), float64(l.crop.Dy())
+	switch g.scaleMode {
+	case "fit":
+		if g.width > 0 && g.height > 0 {
+			f := math.Min(float64(g.width)/sw, float64(g.height)/sh)
+			if !g.upscale {
+				f = math.Min(f, 1)
+			}
+			sw, sh = sw*f, sh*f
+		}
+	case "percent":
+		if g.percent > 0 {
+			sw, sh = sw*g.percent/100, sh*g.percent/100
+		}
+	}
+	padW, padH := float64(g.width), float64(g.height)
+	if g.shrink > 0 && g.shrink < 1 {
+		sw, sh = sw*g.shrink, sh*g.shrink
+		padW, padH = padW*g.shrink, padH*g.shrink
+	}
+	l.scaled = image.Pt(int(math.Max(1, math.Round(sw))), int(math.Max(1, math.Round(sh))))
+
+	l.size = l.scaled
+	if g.pad && g.width > 0 && g.height > 0 {
+		if w := int(math.Round(padW)); w > l.size.X {
+			l.size.X = w
+		}
+		if h := int(math.Round(padH)); h > l.size.Y {
+			l.size.Y = h
+		}
+	}
+	if g.even {
+		if l.scaled == l.crop.Size() {
+			// Crop off the odd row or column rather than resample the
+			// whole frame.
+			l.crop.Max = l.crop.Min.Add(image.Pt(evenCrop(l.crop.Dx()), evenCrop(l.crop.Dy())))
+			l.scaled = l.crop.Size()
+		} else {
+			l.scaled = image.Pt(evenDown(l.scaled.X), evenDown(l.scaled.Y))
+		}
+		l.size = image.Pt(evenDown(l.size.X), evenDown(l.size.Y))
+	}
+	l.offset = l.size.Sub(l.scaled).Div(2)
+	return l
+}
+
+func (l frameLayout) cropped(w, h int) bool {
+	return l.crop != image.Rect(0, 0, w, h)
+}
+
+func (l frameLayout) resized() bool {
+	return l.scaled != l.crop.Size()
+}
+
+func (l frameLayout) padded() bool {
+	return l.size != l.scaled
+}
+
+// identity reports whether the layout leaves a w×h frame untouched.
+func (l frameLayout) identity(w, h int) bool {
+	return !l.cropped(w, h) && !l.resized() && !l.padded()
+}
+
+// apply crops, scales and pads src according to the layout.
+func (l frameLayout) apply(src *image.RGBA, padColor color.RGBA) *image.RGBA {
+	if l.identity(src.Rect.Dx(), src.Rect.Dy()) {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, l.size.X, l.size.Y))
+	if l.padded() {
+		draw.Draw(dst, dst.Rect, image.NewUniform(padColor), image.Point{}, draw.Src)
+	}
+	r := image.Rectangle{Min: l.offset, Max: l.offset.Add(l.scaled)}
+	if l.resized() {
+		draw.CatmullRom.Scale(dst, r, src, l.crop, draw.Src, nil)
+	} else {
+		draw.Draw(dst, r, src, l.crop.Min, draw.Src)
+	}
+	return dst
+}
+
+// ffmpegFilter returns the equivalent ffmpeg filter chain, or an empty string
+// if the frame is left untouched.
+func (l frameLayout) ffmpegFilter(w, h int, padColor color.RGBA) string {
+	var filters []string
+	if l.cropped(w, h) {
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", l.crop.Dx(), l.crop.Dy(), l.crop.Min.X, l.crop.Min.Y))
+	}
+	if l.resized() {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d:flags=lanczos", l.scaled.X, l.scaled.Y))
+	}
+	if l.padded() {
+		filters = append(filters, fmt.Sprintf("pad=%d:%d:%d:%d:color=0x%02x%02x%02x", l.size.X, l.size.Y, l.offset.X, l.offset.Y, padColor.R, padColor.G, padColor.B))
+	}
+	return strings.Join(filters, ",")
+}
+
+// magickArgs returns the equivalent ImageMagick operators.
+func (l frameLayout) magickArgs(w, h int, padColor color.RGBA) (args []string) {
+	if l.cropped(w, h) {
+		args = append(args, "-crop", fmt.Sprintf("%dx%d+%d+%d", l.crop.Dx(), l.crop.Dy(), l.crop.Min.X, l.crop.Min.Y), "+repage")
+	}
+	if l.resized() {
+		args = append(args, "-resize", fmt.Sprintf("%dx%d!", l.scaled.X, l.scaled.Y))
+	}
+	if l.padded() {
+		args = append(args, "-background", formatHexColor(padColor), "-gravity", "center", "-extent", fmt.Sprintf("%dx%d", l.size.X, l.size.Y))
+	}
+	return
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("expected a color like #rrggbb")
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+func formatHexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func makeHexColorEntry(s string) *widget.Entry {
+	e := widget.NewEntry()
+	e.SetText(s)
+	e.Validator = func(s string) error {
+		_, err := parseHexColor(s)
+		return err
+	}
+	return e
+}
+
+func (e *encoder) setupGeometry() *widget.AccordionItem {
+	p := a.Preferences()
+	g := &e.geometry
+
+	cropLabel := widget.NewLabel("Crop (x, y, w, h)")
+	g.cropX, g.cropY = p.Int("cropX"), p.Int("cropY")
+	g.cropW, g.cropH = p.Int("cropW"), p.Int("cropH")
+	cropX, cropY := makeNumberEntry(g.cropX), makeNumberEntry(g.cropY)
+	cropW, cropH := makeNumberEntry(g.cropW), makeNumberEntry(g.cropH)
+	bindInt := func(entry *widget.Entry, key string, v *int) {
+		entry.OnChanged = func(s string) {
+			if n, err := strconv.Atoi(s); err == nil {
+				*v = n
+				p.SetInt(key, n)
+			}
+		}
+	}
+	bindInt(cropX, "cropX", &g.cropX)
+	bindInt(cropY, "cropY", &g.cropY)
+	bindInt(cropW, "cropW", &g.cropW)
+	bindInt(cropH, "cropH", &g.cropH)
+
+	scaleLabel := widget.NewLabel("Scale")
+	g.width, g.height = p.IntWithFallback("scaleWidth", 1920), p.IntWithFallback("scaleHeight", 1080)
+	scaleWidth, scaleHeight := makeNumberEntry(g.width), makeNumberEntry(g.height)
+	bindInt(scaleWidth, "scaleWidth", &g.width)
+	bindInt(scaleHeight, "scaleHeight", &g.height)
+	g.percent = p.FloatWithFallback("scalePercent", 50)
+	scalePercent := widget.NewEntry()
+	scalePercent.SetText(strconv.FormatFloat(g.percent, 'f', -1, 64))
+	scalePercent.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	scalePercent.OnChanged = func(s string) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			g.percent = f
+			p.SetFloat("scalePercent", f)
+		}
+	}
+	scaleModeCombo := widget.NewSelect(scaleModes, func(value string) {
+		g.scaleMode = value
+		p.SetString("scaleMode", value)
+		if value == "percent" {
+			scalePercent.Enable()
+		} else {
+			scalePercent.Disable()
+		}
+	})
+	scaleModeCombo.SetSelected(p.StringWithFallback("scaleMode", "none"))
+
+	sizeLabel := widget.NewLabel("Size (w, h, %)")
+
+	padLabel := widget.NewLabel("Letterbox")
+	g.padColor, _ = parseHexColor(p.StringWithFallback("padColor", "#000000"))
+	padColor := makeHexColorEntry(formatHexColor(g.padColor))
+	padColor.OnChanged = func(s string) {
+		if c, err := parseHexColor(s); err == nil {
+			g.padColor = c
+			p.SetString("padColor", s)
+		}
+	}
+	padCheck := widget.NewCheck("Pad to size", func(value bool) {
+		g.pad = value
+		p.SetBool("padEnabled", value)
+	})
+	padCheck.SetChecked(p.Bool("padEnabled"))
+
+	evenLabel := widget.NewLabel("Even dimensions")
+	evenCheck := widget.NewCheck("Round down to even sizes (x264)", func(value bool) {
+		g.even = value
+		p.SetBool("evenDimensions", value)
+	})
+	evenCheck.SetChecked(p.Bool("evenDimensions"))
+
+	upscaleLabel := widget.NewLabel("Upscaling")
+	upscaleCheck := widget.NewCheck("Let fit enlarge smaller frames", func(value bool) {
+		g.upscale = value
+		p.SetBool("scaleUpscale", value)
+	})
+	upscaleCheck.SetChecked(p.Bool("scaleUpscale"))
+
+	normalizeLabel := widget.NewLabel("Mixed sizes")
+	e.normalizeCombo = widget.NewSelect(normalizeModes, func(value string) {
+		e.normalizeMode = value
+		p.SetString("normalizeMode", value)
+	})
+	e.normalizeCombo.SetSelected(p.StringWithFallback("normalizeMode", "center-pad"))
+
+	return widget.NewAccordionItem("Size", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), normalizeLabel), nil, e.normalizeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), cropLabel), nil,
+			container.NewAdaptiveGrid(4, cropX, cropY, cropW, cropH),
+		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), scaleLabel), nil, scaleModeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), sizeLabel), nil,
+			container.NewAdaptiveGrid(3, scaleWidth, scaleHeight, scalePercent),
+		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), padLabel), nil,
+			container.NewAdaptiveGrid(2, padCheck, padColor),
+		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), upscaleLabel), nil, upscaleCheck),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), evenLabel), nil, evenCheck),
+	))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestFrameGeometryLayout(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		g    frameGeometry
+		w, h int
+		want frameLayout
+	}{
+		{"untouched", frameGeometry{scaleMode: "none"}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(640, 480), size: image.Pt(640, 480)}},
+		{"crop", frameGeometry{cropX: 10, cropY: 20, cropW: 100, cropH: 50}, 640, 480,
+			frameLayout{crop: image.Rect(10, 20, 110, 70), scaled: image.Pt(100, 50), size: image.Pt(100, 50)}},
+		{"crop clipped to the frame", frameGeometry{cropX: 600, cropY: 400, cropW: 100, cropH: 100}, 640, 480,
+			frameLayout{crop: image.Rect(600, 400, 640, 480), scaled: image.Pt(40, 80), size: image.Pt(40, 80)}},
+		{"crop outside the frame is ignored", frameGeometry{cropX: 700, cropY: 0, cropW: 10, cropH: 10}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(640, 480), size: image.Pt(640, 480)}},
+		{"fit", frameGeometry{scaleMode: "fit", width: 320, height: 320}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(320, 240), size: image.Pt(320, 240)}},
+		{"fit does not upscale", frameGeometry{scaleMode: "fit", width: 1280, height: 1280}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(640, 480), size: image.Pt(640, 480)}},
+		{"fit upscales when asked", frameGeometry{scaleMode: "fit", width: 1280, height: 1280, upscale: true}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(1280, 960), size: image.Pt(1280, 960)}},
+		{"fit and pad", frameGeometry{scaleMode: "fit", width: 320, height: 320, pad: true}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(320, 240), size: image.Pt(320, 320), offset: image.Pt(0, 40)}},
+		{"pad without upscaling", frameGeometry{scaleMode: "fit", width: 800, height: 600, pad: true}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(640, 480), size: image.Pt(800, 600), offset: image.Pt(80, 60)}},
+		{"percent", frameGeometry{scaleMode: "percent", percent: 25}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(160, 120), size: image.Pt(160, 120)}},
+		{"percent keeps a pixel", frameGeometry{scaleMode: "percent", percent: 1}, 50, 30,
+			frameLayout{crop: image.Rect(0, 0, 50, 30), scaled: image.Pt(1, 1), size: image.Pt(1, 1)}},
+		{"even crops odd pixels", frameGeometry{scaleMode: "none", even: true}, 641, 481,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(640, 480), size: image.Pt(640, 480)}},
+		{"even rounds scaled sizes down", frameGeometry{scaleMode: "percent", percent: 50, even: true}, 642, 482,
+			frameLayout{crop: image.Rect(0, 0, 642, 482), scaled: image.Pt(320, 240), size: image.Pt(320, 240)}},
+		{"even pads", frameGeometry{scaleMode: "fit", width: 301, height: 301, pad: true, even: true}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(300, 226), size: image.Pt(300, 300), offset: image.Pt(0, 37)}},
+		{"shrink", frameGeometry{scaleMode: "fit", width: 320, height: 320, pad: true, shrink: 0.5}, 640, 480,
+			frameLayout{crop: image.Rect(0, 0, 640, 480), scaled: image.Pt(160, 120), size: image.Pt(160, 160), offset: image.Pt(0, 20)}},
+	} {
+		if got := tt.g.layout(tt.w, tt.h); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFrameLayoutApply(t *testing.T) {
+	src := testFrames(1, image.Pt(40, 30))[0]
+	if l := (frameGeometry{scaleMode: "none"}).layout(40, 30); l.apply(src, color.RGBA{}) != src {
+		t.Error("untouched frame was copied")
+	}
+
+	pad := color.RGBA{0, 255, 0, 255}
+	l := frameGeometry{cropX: 4, cropY: 4, cropW: 20, cropH: 10, scaleMode: "fit", width: 40, height: 40, upscale: true, pad: true}.layout(40, 30)
+	dst := l.apply(src, pad)
+	if dst.Rect.Size() != l.size {
+		t.Fatalf("size %v, want %v", dst.Rect.Size(), l.size)
+	}
+	if dst.RGBAAt(0, 0) != pad || dst.RGBAAt(39, 39) != pad {
+		t.Error("letterbox is not the pad color")
+	}
+	if c := dst.RGBAAt(20, 20); c == pad {
+		t.Error("frame is not drawn in the middle")
+	}
+}
+
+func TestFrameLayoutFilters(t *testing.T) {
+	l := frameGeometry{cropX: 10, cropY: 20, cropW: 100, cropH: 50, scaleMode: "fit", width: 200, height: 200, upscale: true, pad: true}.layout(640, 480)
+	pad := color.RGBA{1, 2, 3, 255}
+	if got, want := l.ffmpegFilter(640, 480, pad), "crop=100:50:10:20,scale=200:100:flags=lanczos,pad=200:200:0:50:color=0x010203"; got != want {
+		t.Errorf("ffmpeg filter %q, want %q", got, want)
+	}
+	got := l.magickArgs(640, 480, pad)
+	want := []string{"-crop", "100x50+10+20", "+repage", "-resize", "200x100!", "-background", "#010203", "-gravity", "center", "-extent", "200x200"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("magick args %q, want %q", got, want)
+	}
+	if f := (frameGeometry{}).layout(640, 480).ffmpegFilter(640, 480, pad); f != "" {
+		t.Errorf("untouched frame filtered with %q", f)
+	}
+}
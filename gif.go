@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"sort"
+)
+
+var gifPaletteModes = []string{"global", "per-frame"}
+var gifDitherModes = []string{"none", "floyd-steinberg", "ordered"}
+
+// colorHistogram counts colors at 5 bits per channel, keeping the full
+// precision sums so palette entries can be averaged accurately.
+type colorHistogram struct {
+	count [32768]uint64
+	sum   [32768][3]uint64
+}
+
+func (h *colorHistogram) add(img *image.RGBA, step int) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		i := img.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, b := img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+			k := int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+			h.count[k]++
+			h.sum[k][0] += uint64(r)
+			h.sum[k][1] += uint64(g)
+			h.sum[k][2] += uint64(b)
+			i += 4 * step
+		}
+	}
+}
+
+type colorBox struct {
+	keys  []int
+	count uint64
+}
+
+func (h *colorHistogram) boxRange(box colorBox) (channel int, span int) {
+	lo := [3]int{31, 31, 31}
+	hi := [3]int{0, 0, 0}
+	for _, k := range box.keys {
+		c := [3]int{k >> 10, (k >> 5) & 31, k & 31}
+		for i := 0; i < 3; i++ {
+			if c[i] < lo[i] {
+				lo[i] = c[i]
+			}
+			if c[i] > hi[i] {
+				hi[i] = c[i]
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if hi[i]-lo[i] > span {
+			span = hi[i] - lo[i]
+			channel = i
+		}
+	}
+	return
+}
+
+// medianCut reduces the histogram to at most n colors.
+func (h *colorHistogram) medianCut(n int) color.Palette {
+	var root colorBox
+	for k, c := range h.count {
+		if c > 0 {
+			root.keys = append(root.keys, k)
+			root.count += c
+		}
+	}
+	if len(root.keys) == 0 {
+		return color.Palette{color.RGBA{0, 0, 0, 255}}
+	}
+
+	boxes := []colorBox{root}
+	for len(boxes) < n {
+		// Split the box with the most weighted spread.
+		best, bestScore, bestChannel := -1, uint64(0), 0
+		for i, box := range boxes {
+			if len(box.keys) < 2 {
+				continue
+			}
+			channel, span := h.boxRange(box)
+			if score := box.count * uint64(span); score > bestScore {
+				best, bestScore, bestChannel = i, score, channel
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		shift := uint(10 - bestChannel*5)
+		sort.Slice(box.keys, func(i, j int) bool {
+			return (box.keys[i]>>shift)&31 < (box.keys[j]>>shift)&31
+		})
+		var acc uint64
+		split := 1
+		for i, k := range box.keys {
+			acc += h.count[k]
+			if acc*2 >= box.count {
+				split = i + 1
+				break
+			}
+		}
+		if split >= len(box.keys) {
+			split = len(box.keys) - 1
+		}
+		a, b := colorBox{keys: box.keys[:split]}, colorBox{keys: box.keys[split:]}
+		for _, k := range a.keys {
+			a.count += h.count[k]
+		}
+		b.count = box.count - a.count
+		boxes[best] = a
+		boxes = append(boxes, b)
+	}
+
+	p := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b uint64
+		for _, k := range box.keys {
+			r += h.sum[k][0]
+			g += h.sum[k][1]
+			b += h.sum[k][2]
+		}
+		p = append(p, color.RGBA{uint8(r / box.count), uint8(g / box.count), uint8(b / box.count), 255})
+	}
+	return p
+}
+
+// quantizer maps colors to their nearest palette entry, caching lookups at
+// 6 bits per channel.
+type quantizer struct {
+	colors [][3]int32
+	cache  []int16
+}
+
+func newQuantizer(p color.Palette) *quantizer {
+	q := &quantizer{
+		colors: make([][3]int32, len(p)),
+		cache:  make([]int16, 1<<18),
+	}
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		q.colors[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	return q
+}
+
+func clamp8(v int32) int32 {
+	if v < 0 {
+		return 0
+	} else if v > 255 {
+		return 255
+	}
+	return v
+}
+
+func (q *quantizer) index(r, g, b int32) uint8 {
+	k := int(r>>2)<<12 | int(g>>2)<<6 | int(b>>2)
+	if v := q.cache[k]; v > 0 {
+		return uint8(v - 1)
+	}
+	best, bestDist := 0, int32(math.MaxInt32)
+	for i, c := range q.colors {
+		dr, dg, db := c[0]-r, c[1]-g, c[2]-b
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	q.cache[k] = int16(best + 1)
+	return uint8(best)
+}
+
+var bayer4 = [4][4]int32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// quantize converts img into a paletted image using the given dithering.
+func (q *quantizer) quantize(img *image.RGBA, p color.Palette, dither string) *image.Paletted {
+	b := img.Bounds()
+	out := image.NewPaletted(b, p)
+	w := b.Dx()
+
+	var cur, next [][3]int32
+	if dither == "floyd-steinberg" {
+		cur = make([][3]int32, w+2)
+		next = make([][3]int32, w+2)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		o := out.PixOffset(b.Min.X, y)
+		for x := 0; x < w; x++ {
+			r, g, bl := int32(img.Pix[i]), int32(img.Pix[i+1]), int32(img.Pix[i+2])
+			switch dither {
+			case "floyd-steinberg":
+				r = clamp8(r + cur[x+1][0]/16)
+				g = clamp8(g + cur[x+1][1]/16)
+				bl = clamp8(bl + cur[x+1][2]/16)
+			case "ordered":
+				t := (bayer4[y&3][x&3] - 8) * 4
+				r, g, bl = clamp8(r+t), clamp8(g+t), clamp8(bl+t)
+			}
+			idx := q.index(r, g, bl)
+			out.Pix[o+x] = idx
+			if cur != nil {
+				c := q.colors[idx]
+				er := [3]int32{r - c[0], g - c[1], bl - c[2]}
+				for ch := 0; ch < 3; ch++ {
+					cur[x+2][ch] += er[ch] * 7
+					next[x][ch] += er[ch] * 3
+					next[x+1][ch] += er[ch] * 5
+					next[x+2][ch] += er[ch]
+				}
+			}
+			i += 4
+		}
+		if cur != nil {
+			cur, next = next, cur
+			for x := range next {
+				next[x] = [3]int32{}
+			}
+		}
+	}
+	return out
+}
+
+func toRGBA(m image.Image) *image.RGBA {
+	if rgba, ok := m.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := m.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, m, b.Min, draw.Src)
+	return rgba
+}
+
+// maxGIFDelay is the longest a GIF frame can be shown, in hundredths of a
+// second.
+const maxGIFDelay = math.MaxUint16
+
+// gifSink quantizes frames into an animated GIF, writing each as soon as the
+// next shows how long it stays, so only one frame is held in memory. Frames
+// are encoded by image/gif, one at a time.
+type gifSink struct {
+	w        io.Writer
+	palette  string
+	dither   string
+	optimize bool
+	colors   int
+	loop     int
+
+	hist    colorHistogram
+	global  color.Palette
+	globalQ *quantizer
+	canvas  *image.RGBA
+	carry   float64
+	pending *gifFrame
+	started bool
+}
+
+// gifFrame is a quantized frame waiting to be written.
+type gifFrame struct {
+	m     *image.Paletted
+	delay int
+}
+
+func (e *encoder) newGIFSink(out io.Writer) *gifSink {
+	s := &gifSink{
+		w:        out,
+		palette:  e.gifPalette,
+		dither:   e.gifDither,
+		optimize: e.gifOptimize,
+		colors:   256,
+		loop:     e.loop.gifLoopCount(),
+	}
+	if e.fit.colors > 0 {
+		s.colors = e.fit.colors
+	}
+	if s.optimize {
+		// Reserve the last entry for transparency.
+		s.colors--
+	}
+	return s
+}
+
+func (s *gifSink) needsAnalysis() bool {
+	return s.palette != "per-frame"
+}
+
+func (s *gifSink) analyze(m *image.RGBA) {
+	s.hist.add(m, 2)
+}
+
+func (s *gifSink) add(rgba *image.RGBA, delay float64) error {
+	if s.needsAnalysis() && s.globalQ == nil {
+		s.global = s.hist.medianCut(s.colors)
+		s.globalQ = newQuantizer(s.global)
+		if s.optimize {
+			s.global = append(s.global, color.RGBA{})
+		}
+	}
+	first := s.canvas == nil
+	if first {
+		s.canvas = image.NewRGBA(rgba.Rect)
+	}
+	// Carry rounding errors so the total stays accurate.
+	exact := delay*100 + s.carry
+	centis := int(math.Round(exact))
+	s.carry = exact - float64(centis)
+
+	p, q := s.global, s.globalQ
+	if q == nil {
+		var h colorHistogram
+		h.add(rgba, 1)
+		p = h.medianCut(s.colors)
+		q = newQuantizer(p)
+		if s.optimize {
+			p = append(p, color.RGBA{})
+		}
+	}
+	frame := q.quantize(rgba, p, s.dither)
+
+	if !s.optimize {
+		return s.queue(&gifFrame{m: frame, delay: centis})
+	}
+
+	bounds := s.canvas.Rect
+	if !first {
+		bounds = changedBounds(frame, s.canvas)
+		if bounds.Empty() {
+			s.pending.delay += centis
+			return nil
+		}
+	}
+	transparent := uint8(len(p) - 1)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			o := frame.PixOffset(x, y)
+			c := p[frame.Pix[o]].(color.RGBA)
+			if !first && s.canvas.RGBAAt(x, y) == c {
+				frame.Pix[o] = transparent
+			} else {
+				s.canvas.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return s.queue(&gifFrame{m: frame.SubImage(bounds).(*image.Paletted), delay: centis})
+}
+
+// queue writes the pending frame and holds back f in its place.
+func (s *gifSink) queue(f *gifFrame) error {
+	if s.pending != nil {
+		if err := s.writeFrame(s.pending); err != nil {
+			return err
+		}
+	}
+	s.pending = f
+	return nil
+}
+
+func (s *gifSink) close() error {
+	if s.pending == nil {
+		return fmt.Errorf("no frames were decoded")
+	}
+	if err := s.writeFrame(s.pending); err != nil {
+		return err
+	}
+	_, err := s.w.Write([]byte{0x3b})
+	return err
+}
+
+// encodeFrame encodes m as a GIF of its own, returning the header, which
+// holds the screen descriptor and any global color table, and the blocks of
+// the frame.
+func (s *gifSink) encodeFrame(m *image.Paletted, delay int) (header, frame []byte, err error) {
+	g := gif.GIF{
+		Image:  []*image.Paletted{m},
+		Delay:  []int{delay},
+		Config: image.Config{ColorModel: s.global, Width: s.canvas.Rect.Dx(), Height: s.canvas.Rect.Dy()},
+	}
+	if s.optimize {
+		g.Disposal = []byte{gif.DisposalNone}
+	}
+	if s.global == nil {
+		// image/gif would take the palette of the frame as the global one.
+		// A stand-in of another size keeps it with the frame.
+		size := 2
+		if len(m.Palette) <= 128 {
+			size = 256
+		}
+		standIn := make(color.Palette, size)
+		for i := range standIn {
+			standIn[i] = color.Black
+		}
+		g.Config.ColorModel = standIn
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, &g); err != nil {
+		return nil, nil, err
+	}
+	data := b.Bytes()
+	n := 13
+	if data[10]&0x80 != 0 {
+		n += 3 << (data[10]&7 + 1)
+	}
+	// The trailer is left for close.
+	return data[:n:n], data[n : len(data)-1], nil
+}
+
+// writeFrame writes f, after the header if it is the first. Delays too long
+// for one frame are carried on by see-through frames of a single pixel.
+func (s *gifSink) writeFrame(f *gifFrame) error {
+	delay := f.delay
+	if delay > maxGIFDelay {
+		delay = maxGIFDelay
+	}
+	header, frame, err := s.encodeFrame(f.m, delay)
+	if err != nil {
+		return err
+	}
+	if !s.started {
+		s.started = true
+		if s.loop >= 0 {
+			// image/gif only writes the loop count along with every frame
+			// at once.
+			loop := []byte{0x21, 0xff, 11, 'N', 'E', 'T', 'S', 'C', 'A', 'P', 'E', '2', '.', '0', 3, 1, 0, 0, 0}
+			binary.LittleEndian.PutUint16(loop[16:], uint16(s.loop))
+			header = append(header, loop...)
+		}
+		if _, err := s.w.Write(header); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	hold := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.RGBA{}})
+	for rest := f.delay - delay; rest > 0; rest -= delay {
+		delay = rest
+		if delay > maxGIFDelay {
+			delay = maxGIFDelay
+		}
+		_, frame, err := s.encodeFrame(hold, delay)
+		if err != nil {
+			return err
+		}
+		if _, err := s.w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedBounds returns the bounding rectangle of the pixels in frame that
+// differ from canvas.
+func changedBounds(frame *image.Paletted, canvas *image.RGBA) image.Rectangle {
+	colors := make([]color.RGBA, len(frame.Palette))
+	for i, c := range frame.Palette {
+		colors[i] = c.(color.RGBA)
+	}
+	b := frame.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		o := frame.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if colors[frame.Pix[o]] != canvas.RGBAAt(x, y) {
+				if x < minX {
+					minX = x
+				}
+				if x >= maxX {
+					maxX = x + 1
+				}
+				if y < minY {
+					minY = y
+				}
+				maxY = y + 1
+			}
+			o++
+		}
+	}
+	// Built directly, as image.Rect would swap the bounds of an unchanged
+	// frame rather than leave it empty.
+	return image.Rectangle{Min: image.Pt(minX, minY), Max: image.Pt(maxX, maxY)}
+}
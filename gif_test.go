@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+// encodeTestGIF writes frames through a gifSink set up by e.
+func encodeTestGIF(t *testing.T, e encoder, frames []*image.RGBA, delays []float64) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	s := e.newGIFSink(&b)
+	if s.needsAnalysis() {
+		for _, m := range frames {
+			s.analyze(m)
+		}
+	}
+	for i, m := range frames {
+		if err := s.add(m, delays[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// composite draws the frames of g in turn, as a viewer would, returning the
+// canvas after each.
+func composite(g *gif.GIF) []*image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	var shown []*image.RGBA
+	for _, m := range g.Image {
+		draw.Draw(canvas, m.Rect, m, m.Rect.Min, draw.Over)
+		c := image.NewRGBA(canvas.Rect)
+		copy(c.Pix, canvas.Pix)
+		shown = append(shown, c)
+	}
+	return shown
+}
+
+// closeTo reports whether a and b differ by at most tolerance per channel
+// on average.
+func closeTo(a, b *image.RGBA, tolerance int) bool {
+	var diff int
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		diff += d
+	}
+	return diff/len(a.Pix) <= tolerance
+}
+
+func TestGIFSinkRoundTrip(t *testing.T) {
+	frames := testFrames(5, image.Pt(48, 32))
+	// The fourth frame repeats the third, so optimizing merges them.
+	frames[3] = frames[2]
+	delays := []float64{0.1, 0.2, 0.1, 0.3, 0.5}
+	for _, palette := range gifPaletteModes {
+		for _, optimize := range []bool{false, true} {
+			var e encoder
+			e.gifPalette, e.gifDither, e.gifOptimize = palette, "none", optimize
+			e.loop.plays = 3
+			g, err := gif.DecodeAll(bytes.NewReader(encodeTestGIF(t, e, frames, delays)))
+			if err != nil {
+				t.Fatalf("%s, optimize %v: %v", palette, optimize, err)
+			}
+			wantDelays, wantFrames := []int{10, 20, 10, 30, 50}, frames
+			if optimize {
+				wantDelays = []int{10, 20, 40, 50}
+				wantFrames = []*image.RGBA{frames[0], frames[1], frames[2], frames[4]}
+			}
+			if !equalInts(g.Delay, wantDelays) {
+				t.Errorf("%s, optimize %v: delays %v, want %v", palette, optimize, g.Delay, wantDelays)
+			}
+			if g.LoopCount != 2 {
+				t.Errorf("%s, optimize %v: loop count %d, want 2", palette, optimize, g.LoopCount)
+			}
+			shown := composite(g)
+			if len(shown) != len(wantFrames) {
+				t.Fatalf("%s, optimize %v: %d frames, want %d", palette, optimize, len(shown), len(wantFrames))
+			}
+			for i := range shown {
+				if frames[nearestFrame(shown[i], frames)] != wantFrames[i] || !closeTo(shown[i], wantFrames[i], 8) {
+					t.Errorf("%s, optimize %v: frame %d does not match", palette, optimize, i)
+				}
+			}
+		}
+	}
+}
+
+func TestGIFSinkPlaysOnce(t *testing.T) {
+	var e encoder
+	e.gifPalette, e.gifDither, e.loop.plays = "global", "none", 1
+	g, err := gif.DecodeAll(bytes.NewReader(encodeTestGIF(t, e, testFrames(2, image.Pt(16, 16)), []float64{0.1, 0.1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.LoopCount != -1 {
+		t.Errorf("loop count %d, want -1", g.LoopCount)
+	}
+}
+
+func TestGIFSinkLongHold(t *testing.T) {
+	var e encoder
+	e.gifPalette, e.gifDither = "per-frame", "none"
+	frames := testFrames(2, image.Pt(16, 16))
+	g, err := gif.DecodeAll(bytes.NewReader(encodeTestGIF(t, e, frames, []float64{1500, 0.1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{65535, 65535, 18930, 10}; !equalInts(g.Delay, want) {
+		t.Errorf("delays %v, want %v", g.Delay, want)
+	}
+	shown := composite(g)
+	if nearestFrame(shown[2], frames) != 0 || !closeTo(shown[2], frames[0], 8) {
+		t.Error("the first frame does not stay shown through its hold")
+	}
+}
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestGIFSinkWriteError(t *testing.T) {
+	var e encoder
+	e.gifPalette, e.gifDither = "global", "none"
+	s := e.newGIFSink(&failingWriter{n: 1000})
+	frames := testFrames(8, image.Pt(48, 32))
+	for _, m := range frames {
+		s.analyze(m)
+	}
+	var err error
+	for _, m := range frames {
+		if err = s.add(m, 0.1); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = s.close()
+	}
+	if err == nil {
+		t.Error("the write error was lost")
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
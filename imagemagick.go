@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerBackend(imageMagickBackend{})
+}
+
+// magickFormat is an output type of the ImageMagick backend.
+type magickFormat struct {
+	kind string
+	// format is the ImageMagick name of the format, used as the prefix
+	// of the output path.
+	format string
+}
+
+var magickFormats = []magickFormat{
+	{"gif", "GIF"},
+	{"png", "APNG"},
+	{"webp", "WEBP"},
+}
+
+// magickTools returns the capabilities of magick and convert, in the order
+// they are preferred.
+func magickTools() []toolCapabilities {
+	return []toolCapabilities{aSettings.magickCaps, aSettings.convertCaps}
+}
+
+// tool returns the path of the tool that writes f and whether one can, as far
+// as probing found. Tools that were not probed are assumed to write GIF and
+// APNG.
+func (f magickFormat) tool() (string, bool) {
+	for _, caps := range magickTools() {
+		if caps.path == "" {
+			continue
+		}
+		if caps.probed() && caps.formats[f.format] || !caps.probed() && f.kind != "webp" {
+			return caps.path, true
+		}
+	}
+	return "", false
+}
+
+// describeMagick lists the formats caps can write that gosh uses.
+func describeMagick(caps toolCapabilities) string {
+	var formats []string
+	for _, f := range magickFormats {
+		if caps.formats[f.format] {
+			formats = append(formats, f.kind)
+		}
+	}
+	if len(formats) == 0 {
+		return caps.String()
+	}
+	return caps.String() + "\n" + strings.Join(formats, ", ")
+}
+
+// imageMagickBackend encodes through magick, or convert for formats magick
+// cannot write or when it is not installed.
+type imageMagickBackend struct{}
+
+func (imageMagickBackend) name() string {
+	return "imagemagick"
+}
+
+// available reports whether either tool was found and writes any format.
+// Which formats it writes is left to formats.
+func (imageMagickBackend) available() bool {
+	for _, caps := range magickTools() {
+		if caps.path != "" && (!caps.probed() || len(caps.formats) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (imageMagickBackend) formats() (types []string) {
+	for _, f := range magickFormats {
+		if _, ok := f.tool(); ok {
+			types = append(types, f.kind)
+		}
+	}
+	return
+}
+
+func (imageMagickBackend) mixedFormats() bool {
+	return true
+}
+
+func (imageMagickBackend) layouts() bool {
+	return true
+}
+
+func (imageMagickBackend) encode(e *encoder, job *encodeJob, kinds []string, f *encodeFrames) error {
+	// Types may be written by different tools, so each is its own run.
+	for _, kind := range kinds {
+		var format magickFormat
+		for _, m := range magickFormats {
+			if m.kind == kind {
+				format = m
+			}
+		}
+		cmdPath, ok := format.tool()
+		if format.kind == "" || !ok {
+			return fmt.Errorf("%s is not supported by the imagemagick backend", kind)
+		}
+		loop := e.loop.magickArgs()
+		var args []string
+		if f.uniform {
+			// convert fps to imagemagick delay:
+			args = append(args, "-delay", strconv.Itoa(int(100/f.fps)))
+			args = append(args, loop...)
+			args = append(args, f.files...)
+		} else {
+			args = append(args, loop...)
+			for i, centis := range centiseconds(f.delays) {
+				args = append(args, "-delay", strconv.Itoa(centis), f.files[i])
+			}
+		}
+		args = append(args, f.layout.magickArgs(f.base.X, f.base.Y, e.geometry.padColor)...)
+		if kind == "gif" && e.fit.colors > 0 {
+			args = append(args, "-colors", strconv.Itoa(e.fit.colors))
+		}
+		args = append(args, format.format+":"+outputFile(job.Output, kind))
+
+		if err := e.runCmd(job, cmdPath, f.dir, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
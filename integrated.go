@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerBackend(integratedBackend{})
+}
+
+// integratedBackend encodes in gosh itself and is always available.
+type integratedBackend struct{}
+
+func (integratedBackend) name() string {
+	return "integrated"
+}
+
+func (integratedBackend) available() bool {
+	return true
+}
+
+func (integratedBackend) formats() []string {
+	return []string{"png", "gif", "avi"}
+}
+
+func (integratedBackend) encode(e *encoder, job *encodeJob, kinds []string, f *encodeFrames) error {
+	if e.commands != nil {
+		for _, kind := range kinds {
+			*e.commands = append(*e.commands, previewedCommand{integrated: outputFile(job.Output, kind)})
+		}
+		return nil
+	}
+	e.report("processing...")
+	summary, err := e.encodeIntegrated(f.load, f.canvas, job.Output, kinds, f.delays)
+	if err != nil {
+		return err
+	}
+	job.logf("%s", summary)
+	return nil
+}
+
+// frameLoader returns the i-th frame of an encode, ready to be written.
+type frameLoader func(i int) (*image.RGBA, error)
+
+// frameSink receives the frames of an integrated encode in order.
+type frameSink interface {
+	add(m *image.RGBA, delay float64) error
+	close() error
+}
+
+// frameAnalyzer is implemented by sinks that must see every frame before the
+// first one is added, such as a GIF with a global palette.
+type frameAnalyzer interface {
+	needsAnalysis() bool
+	analyze(m *image.RGBA)
+}
+
+// encodeIntegrated decodes each frame once and feeds it to a sink for every
+// one of kinds, returning a summary of the files written. Each frame is shown
+// for the matching entry of delays, in seconds.
+func (e *encoder) encodeIntegrated(load frameLoader, canvas image.Rectangle, outpath string, kinds []string, delays []float64) (string, error) {
+	n := len(delays)
+	if n == 0 {
+		return "", fmt.Errorf("no frames to encode")
+	}
+
+	var outs []*os.File
+	defer func() {
+		for _, f := range outs {
+			f.Close()
+		}
+	}()
+	var sinks []frameSink
+	var analyzers []frameAnalyzer
+	for _, kind := range kinds {
+		out, err := os.Create(outputFile(outpath, kind))
+		if err != nil {
+			return "", err
+		}
+		outs = append(outs, out)
+		var sink frameSink
+		switch kind {
+		case "gif":
+			sink = e.newGIFSink(out)
+		case "png":
+			if sink, err = newAPNGSink(out, canvas, n, e.apngOptimize, uint(e.loop.plays)); err != nil {
+				return "", err
+			}
+		case "avi":
+			if sink, err = newAVISink(out, canvas, delays, e.aviQuality); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("%s is not supported by the integrated backend", kind)
+		}
+		sinks = append(sinks, sink)
+		if a, ok := sink.(frameAnalyzer); ok && a.needsAnalysis() {
+			analyzers = append(analyzers, a)
+		}
+	}
+
+	if len(analyzers) > 0 {
+		err := e.eachFrame(n, load, canvas, "analyzing", func(i int, m *image.RGBA) error {
+			for _, a := range analyzers {
+				a.analyze(m)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	err := e.eachFrame(n, load, canvas, "processing", func(i int, m *image.RGBA) error {
+		for _, s := range sinks {
+			if err := s.add(m, delays[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var summary []string
+	for i, s := range sinks {
+		if err := s.close(); err != nil {
+			return "", err
+		}
+		var info string
+		if a, ok := s.(*apngSink); ok {
+			info = a.stats.String()
+		} else if st, err := outs[i].Stat(); err == nil {
+			info = fmt.Sprintf("%.2f MB", float64(st.Size())/1024/1024)
+		}
+		if e.notes != nil {
+			e.notes[kinds[i]] = info
+		}
+		summary = append(summary, kinds[i]+" "+info)
+	}
+	return strings.Join(summary, "; "), nil
+}
+
+// eachFrame loads n frames in parallel and calls fn with each in order.
+func (e *encoder) eachFrame(n int, load frameLoader, canvas image.Rectangle, verb string, fn func(i int, m *image.RGBA) error) error {
+	done := make(chan struct{})
+	defer close(done)
+	results := runOrdered(n, 0, done, func(i int) (*image.RGBA, error) {
+		rgba, err := load(i)
+		if err != nil {
+			return nil, err
+		}
+		if rgba.Rect != canvas {
+			return nil, fmt.Errorf("frame %d is %dx%d, expected %dx%d", i+1, rgba.Rect.Dx(), rgba.Rect.Dy(), canvas.Dx(), canvas.Dy())
+		}
+		return rgba, nil
+	})
+	i := 0
+	for r := range results {
+		if r.err != nil {
+			return r.err
+		}
+		e.report(fmt.Sprintf("%s %d/%d", verb, i+1, n))
+		if err := fn(i, r.value); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
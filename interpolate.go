@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var interpolationCurves = []string{"linear", "ease-in-out", "ease-in", "ease-out"}
+var interpolationMethods = []string{"blend", "ffmpeg framerate", "ffmpeg minterpolate"}
+
+// frameInterpolation generates in-between frames to smooth out timelapses.
+type frameInterpolation struct {
+	steps  int
+	curve  string
+	method string
+}
+
+// interpolatedFrame locates an output frame between two source frames.
+type interpolatedFrame struct {
+	index int
+	// t is the position towards the next source frame, from 0 up to 1.
+	t float64
+}
+
+func (f frameInterpolation) active() bool {
+	return f.steps > 0
+}
+
+// weight maps a position between two frames to how much of the second frame
+// is shown.
+func (f frameInterpolation) weight(t float64) float64 {
+	switch f.curve {
+	case "ease-in-out":
+		return t * t * (3 - 2*t)
+	case "ease-in":
+		return t * t
+	case "ease-out":
+		return 1 - (1-t)*(1-t)
+	}
+	return t
+}
+
+// wrap splits the time of every frame but the last among steps+1 frames and
+// returns a loader that crossfades towards the following frame.
+func (f frameInterpolation) wrap(delays []float64, load frameLoader) ([]float64, []interpolatedFrame, frameLoader) {
+	var out []float64
+	var frames []interpolatedFrame
+	for i, d := range delays {
+		if i == len(delays)-1 {
+			out = append(out, d)
+			frames = append(frames, interpolatedFrame{index: i})
+			break
+		}
+		for k := 0; k <= f.steps; k++ {
+			out = append(out, d/float64(f.steps+1))
+			frames = append(frames, interpolatedFrame{i, float64(k) / float64(f.steps+1)})
+		}
+	}
+	cache := newFrameCache(load, runtime.NumCPU()+2)
+	return out, frames, func(i int) (*image.RGBA, error) {
+		fr := frames[i]
+		a, err := cache.get(fr.index)
+		if err != nil {
+			return nil, err
+		} else if fr.t == 0 {
+			// Frames are drawn on after loading, so the cached one is copied.
+			m := image.NewRGBA(a.Rect)
+			copy(m.Pix, a.Pix)
+			return m, nil
+		}
+		b, err := cache.get(fr.index + 1)
+		if err != nil {
+			return nil, err
+		}
+		return blendFrames(a, b, f.weight(fr.t)), nil
+	}
+}
+
+// frameCache keeps the last size source frames loaded, so the frames blended
+// between a pair, loaded in parallel, decode each of the pair only once.
+type frameCache struct {
+	load    frameLoader
+	size    int
+	mu      sync.Mutex
+	entries map[int]*cachedFrame
+	order   []int
+}
+
+type cachedFrame struct {
+	once sync.Once
+	m    *image.RGBA
+	err  error
+}
+
+func newFrameCache(load frameLoader, size int) *frameCache {
+	return &frameCache{load: load, size: size, entries: make(map[int]*cachedFrame)}
+}
+
+// get returns the i-th frame, waiting for it if another worker is loading it.
+func (c *frameCache) get(i int) (*image.RGBA, error) {
+	c.mu.Lock()
+	f, ok := c.entries[i]
+	if !ok {
+		f = &cachedFrame{}
+		c.entries[i] = f
+		c.order = append(c.order, i)
+		if len(c.order) > c.size {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.mu.Unlock()
+	f.once.Do(func() {
+		f.m, f.err = c.load(i)
+	})
+	return f.m, f.err
+}
+
+// ffmpegFilter returns the ffmpeg filter that interpolates a stream of fps
+// frames per second.
+func (f frameInterpolation) ffmpegFilter(fps float64) string {
+	rate := strconv.FormatFloat(fps*float64(f.steps+1), 'f', -1, 64)
+	if f.method == "ffmpeg minterpolate" {
+		return fmt.Sprintf("minterpolate=fps=%s:mi_mode=mci", rate)
+	}
+	return fmt.Sprintf("framerate=fps=%s", rate)
+}
+
+// blendFrames mixes b into a by w, from 0 to 1.
+func blendFrames(a, b *image.RGBA, w float64) *image.RGBA {
+	if a.Rect != b.Rect {
+		return a
+	}
+	wb := uint32(w*256 + 0.5)
+	wa := 256 - wb
+	m := image.NewRGBA(a.Rect)
+	for i := range m.Pix {
+		m.Pix[i] = uint8((uint32(a.Pix[i])*wa + uint32(b.Pix[i])*wb) >> 8)
+	}
+	return m
+}
+
+func (e *encoder) setupInterpolation() *widget.AccordionItem {
+	p := a.Preferences()
+	f := &e.interpolation
+
+	stepsLabel := widget.NewLabel("In-between frames")
+	f.steps = p.Int("interpolationSteps")
+	stepsInput := makeNumberEntry(f.steps)
+	stepsInput.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			f.steps = n
+			p.SetInt("interpolationSteps", n)
+		}
+	}
+
+	curveLabel := widget.NewLabel("Blend curve")
+	curveCombo := widget.NewSelect(interpolationCurves, func(value string) {
+		f.curve = value
+		p.SetString("interpolationCurve", value)
+	})
+	curveCombo.SetSelected(p.StringWithFallback("interpolationCurve", "linear"))
+
+	methodLabel := widget.NewLabel("Method")
+	methodCombo := widget.NewSelect(interpolationMethods, func(value string) {
+		f.method = value
+		p.SetString("interpolationMethod", value)
+		if value == "blend" {
+			curveCombo.Enable()
+		} else {
+			curveCombo.Disable()
+		}
+	})
+	methodCombo.SetSelected(p.StringWithFallback("interpolationMethod", "blend"))
+
+	return widget.NewAccordionItem("Interpolation", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), stepsLabel), nil, stepsInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), methodLabel), nil, methodCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), curveLabel), nil, curveCombo),
+		widget.NewLabel("The ffmpeg methods apply to the ffmpeg backend at a constant frame rate,\nother encodes blend in gosh."),
+	))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// liveKinds returns the ffmpeg output types the recorder can stream into,
+// leaving out those the encoder would take for frames.
+func liveKinds() (kinds []string) {
+	for i := range ffmpegFormats {
+		f := &ffmpegFormats[i]
+		if f.codec(aSettings.ffmpegCaps) != nil && !isFrameFile(outputFile("", f.kind)) {
+			kinds = append(kinds, f.kind)
+		}
+	}
+	return
+}
+
+// liveEncoder streams captured frames into a running ffmpeg process as raw
+// RGBA video, so the video is ready as soon as recording stops.
+type liveEncoder struct {
+	// path is a strftime template when ffmpeg splits the video into
+	// segments.
+	path    string
+	split   *liveSplit
+	started time.Time
+	size    image.Point
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  tailBuffer
+	// exited is closed once ffmpeg exits, with err holding why.
+	exited chan struct{}
+	err    error
+}
+
+// liveSplit has ffmpeg's segment muxer start a new file every length,
+// naming each by the strftime template the encoder is given as its path.
+type liveSplit struct {
+	// length is in seconds of video, or of wall clock time when clock is
+	// set, in which case segments begin on multiples of it from midnight.
+	length float64
+	clock  bool
+	// keyframes is the video time between key frames, which are the only
+	// places a segment can begin.
+	keyframes float64
+}
+
+// startLiveEncoder starts ffmpeg writing kind to path from frames of size
+// shown at fps, split into segments if split is set.
+func startLiveEncoder(kind string, size image.Point, fps float64, path string, split *liveSplit) (*liveEncoder, error) {
+	format := findFFMPEGFormat(kind)
+	if format == nil {
+		return nil, fmt.Errorf("ffmpeg does not write %s", kind)
+	}
+	codec := format.codec(aSettings.ffmpegCaps)
+	if codec == nil {
+		return nil, fmt.Errorf("%s has no %s encoder", aSettings.getFFMPEGPath(), kind)
+	}
+
+	args := []string{
+		"-y",
+		"-nostats",
+		"-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", size.X, size.Y),
+		"-framerate", strconv.FormatFloat(fps, 'f', -1, 64),
+		"-i", "-",
+		"-c:v", codec.encoder,
+	}
+	args = append(args, codec.args...)
+	if split != nil {
+		seconds := func(f float64) string {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		args = append(args,
+			"-force_key_frames", "expr:gte(t,n_forced*"+seconds(split.keyframes)+")",
+			"-f", "segment",
+			"-segment_format", format.muxer,
+			"-segment_time", seconds(split.length),
+		)
+		if split.clock {
+			args = append(args, "-segment_atclocktime", "1")
+		}
+		args = append(args, "-reset_timestamps", "1", "-strftime", "1", path)
+	} else {
+		args = append(args, "-f", format.muxer, path)
+	}
+
+	l := &liveEncoder{path: path, split: split, started: time.Now(), size: size, stderr: tailBuffer{max: 4096}, exited: make(chan struct{})}
+	l.cmd = exec.Command(aSettings.getFFMPEGPath(), args...)
+	l.cmd.Stderr = &l.stderr
+	var err error
+	if l.stdin, err = l.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if err := l.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		l.err = l.cmd.Wait()
+		close(l.exited)
+	}()
+	return l, nil
+}
+
+// strftimeConversion matches the conversions of a strftime template.
+var strftimeConversion = regexp.MustCompile(`%.`)
+
+// written returns how many bytes ffmpeg has written, across every segment.
+func (l *liveEncoder) written() (n int64) {
+	if l.split == nil {
+		if st, err := os.Stat(l.path); err == nil {
+			n = st.Size()
+		}
+		return
+	}
+	matches, _ := filepath.Glob(strftimeConversion.ReplaceAllString(l.path, "*"))
+	for _, m := range matches {
+		if st, err := os.Stat(m); err == nil && !st.ModTime().Before(l.started) {
+			n += st.Size()
+		}
+	}
+	return
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	b   []byte
+	mu  sync.Mutex
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.b = append(t.b, p...)
+	if len(t.b) > t.max {
+		t.b = append(t.b[:0], t.b[len(t.b)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.b)
+}
+
+// write sends one frame to ffmpeg. It fails once ffmpeg has exited.
+func (l *liveEncoder) write(img *image.RGBA) error {
+	select {
+	case <-l.exited:
+		return fmt.Errorf("ffmpeg exited early: %v\n%s", l.err, l.stderr.String())
+	default:
+	}
+	if img.Rect.Size() != l.size {
+		return fmt.Errorf("frame is %v, expected %v", img.Rect.Size(), l.size)
+	}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		i := img.PixOffset(img.Rect.Min.X, y)
+		if _, err := l.stdin.Write(img.Pix[i : i+l.size.X*4]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close ends the stream and waits for ffmpeg to finish the file.
+func (l *liveEncoder) close() error {
+	l.stdin.Close()
+	<-l.exited
+	if l.err != nil {
+		return fmt.Errorf("%v\n%s", l.err, l.stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var loopStyles = []string{"forward", "reverse", "ping-pong"}
+
+// frameLoop rearranges the frames of an encode and decides how often the
+// result plays. It works on the frame list, so it applies to every backend.
+type frameLoop struct {
+	style     string
+	holdFirst float64
+	holdLast  float64
+	// plays is how many times GIF, APNG and WebP outputs play, 0 being
+	// forever.
+	plays int
+}
+
+// apply reorders files and their delays by the loop style, then holds the
+// first and last frames for longer.
+func (l frameLoop) apply(files []string, delays []float64) ([]string, []float64) {
+	if len(files) == 0 {
+		return files, delays
+	}
+	files = append([]string{}, files...)
+	delays = append([]float64{}, delays...)
+	n := len(files)
+	switch l.style {
+	case "reverse":
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+			delays[i], delays[j] = delays[j], delays[i]
+		}
+	case "ping-pong":
+		// The ends are not repeated, so the loop does not stutter.
+		for i := n - 2; i > 0; i-- {
+			files = append(files, files[i])
+			delays = append(delays, delays[i])
+		}
+	}
+	delays[0] += l.holdFirst
+	delays[len(delays)-1] += l.holdLast
+	return files, delays
+}
+
+// gifLoopCount converts plays to the loop count of image/gif, where -1 plays
+// once and n repeats n more times.
+func (l frameLoop) gifLoopCount() int {
+	if l.plays == 0 {
+		return 0
+	} else if l.plays == 1 {
+		return -1
+	}
+	return l.plays - 1
+}
+
+// magickArgs returns the ImageMagick option setting plays. Unlike image/gif,
+// -loop counts every play for each format, GIF included.
+func (l frameLoop) magickArgs() []string {
+	return []string{"-loop", strconv.Itoa(l.plays)}
+}
+
+func (e *encoder) setupLoop() *widget.AccordionItem {
+	p := a.Preferences()
+	l := &e.loop
+
+	styleLabel := widget.NewLabel("Loop style")
+	styleCombo := widget.NewSelect(loopStyles, func(value string) {
+		l.style = value
+		p.SetString("loopStyle", value)
+	})
+	styleCombo.SetSelected(p.StringWithFallback("loopStyle", "forward"))
+
+	playsLabel := widget.NewLabel("Plays")
+	l.plays = p.Int("loopPlays")
+	playsInput := makeNumberEntry(l.plays)
+	playsInput.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			l.plays = n
+			p.SetInt("loopPlays", n)
+		}
+	}
+
+	return widget.NewAccordionItem("Looping", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), styleLabel), nil, styleCombo),
+		makeRow("Hold first (s)", makeFloatEntry("loopHoldFirst", 0, &l.holdFirst)),
+		makeRow("Hold last (s)", makeFloatEntry("loopHoldLast", 0, &l.holdLast)),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), playsLabel), nil, playsInput),
+		widget.NewLabel("Plays applies to GIF, APNG and WebP outputs, 0 loops forever."),
+	))
+}
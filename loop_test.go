@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFrameLoopApply(t *testing.T) {
+	files := []string{"a", "b", "c"}
+	delays := []float64{1, 1, 1}
+	for _, tt := range []struct {
+		loop   frameLoop
+		files  []string
+		delays []float64
+	}{
+		{frameLoop{style: "forward"}, []string{"a", "b", "c"}, []float64{1, 1, 1}},
+		{frameLoop{style: "reverse"}, []string{"c", "b", "a"}, []float64{1, 1, 1}},
+		{frameLoop{style: "ping-pong"}, []string{"a", "b", "c", "b"}, []float64{1, 1, 1, 1}},
+		{frameLoop{style: "forward", holdFirst: 2, holdLast: 0.5}, []string{"a", "b", "c"}, []float64{3, 1, 1.5}},
+	} {
+		gotFiles, gotDelays := tt.loop.apply(files, delays)
+		if !reflect.DeepEqual(gotFiles, tt.files) || !reflect.DeepEqual(gotDelays, tt.delays) {
+			t.Errorf("%+v: got %v %v, want %v %v", tt.loop, gotFiles, gotDelays, tt.files, tt.delays)
+		}
+	}
+	if !reflect.DeepEqual(files, []string{"a", "b", "c"}) || !reflect.DeepEqual(delays, []float64{1, 1, 1}) {
+		t.Errorf("apply changed its input: %v %v", files, delays)
+	}
+	if f, d := (frameLoop{holdFirst: 1}).apply(nil, nil); len(f) != 0 || len(d) != 0 {
+		t.Errorf("empty input gave %v %v", f, d)
+	}
+}
+
+func TestFrameLoopHoldIsNotUniform(t *testing.T) {
+	_, delays := frameLoop{style: "forward", holdLast: 2}.apply([]string{"a", "b", "c"}, []float64{0.1, 0.1, 0.1})
+	if _, ok := uniformRate(delays); ok {
+		t.Errorf("%v with a held last frame reported as uniform", delays)
+	}
+}
+
+func TestFrameLoopCounts(t *testing.T) {
+	for _, tt := range []struct {
+		plays  int
+		gif    int
+		magick []string
+	}{
+		{0, 0, []string{"-loop", "0"}},
+		{1, -1, []string{"-loop", "1"}},
+		{3, 2, []string{"-loop", "3"}},
+	} {
+		l := frameLoop{plays: tt.plays}
+		if got := l.gifLoopCount(); got != tt.gif {
+			t.Errorf("%d plays: gif loop count %d, want %d", tt.plays, got, tt.gif)
+		}
+		if got := l.magickArgs(); !reflect.DeepEqual(got, tt.magick) {
+			t.Errorf("%d plays: magick args %v, want %v", tt.plays, got, tt.magick)
+		}
+	}
+}
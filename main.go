@@ -25,7 +25,11 @@ var a fyne.App
 var aRecorder recorder
 var aEncoder encoder
 var aSettings settings
+var aFrameBrowser frameBrowser
+var aQueue encodeQueue
 var tabs *container.AppTabs
+var encodeTab *container.TabItem
+var framesTab *container.TabItem
 var window fyne.Window
 var windowHidden bool
 var systrayMenu *fyne.Menu
@@ -42,6 +46,45 @@ func makeNumberEntry(number int) *widget.Entry {
 	return e
 }
 
+// makeFloatEntry makes an entry for a number of at least 0, kept in v and in
+// the preference key.
+func makeFloatEntry(key string, fallback float64, v *float64) *widget.Entry {
+	p := a.Preferences()
+	*v = p.FloatWithFallback(key, fallback)
+	e := widget.NewEntry()
+	e.SetText(strconv.FormatFloat(*v, 'f', -1, 64))
+	e.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	e.OnChanged = func(s string) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 {
+			*v = f
+			p.SetFloat(key, f)
+		}
+	}
+	return e
+}
+
+// makeIntEntry is makeFloatEntry for whole numbers.
+func makeIntEntry(key string, fallback int, v *int) *widget.Entry {
+	p := a.Preferences()
+	*v = p.IntWithFallback(key, fallback)
+	e := makeNumberEntry(*v)
+	e.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			*v = n
+			p.SetInt(key, n)
+		}
+	}
+	return e
+}
+
+// makeRow labels an entry in the width settings rows use.
+func makeRow(label string, entry *widget.Entry) *fyne.Container {
+	return container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel(label)), nil, entry)
+}
+
 func main() {
 	a = app.NewWithID("net.kettek.gosh")
 
@@ -87,12 +130,23 @@ func main() {
 
 	aRecorder.setup()
 	aSettings.setup()
+	loadTemplates()
+	aFrameBrowser.setup()
+	aQueue.setup()
 
+	encodeTab = container.NewTabItem("Encode", container.NewPadded())
+	framesTab = container.NewTabItem("Frames", container.NewPadded(aFrameBrowser.container))
 	tabs = container.NewAppTabs(
 		container.NewTabItem("Record", container.NewPadded(aRecorder.container)),
-		container.NewTabItem("Encode", container.NewPadded()),
+		framesTab,
+		encodeTab,
 		container.NewTabItem("Settings", container.NewPadded(aSettings.container)),
 	)
+	tabs.OnSelected = func(t *container.TabItem) {
+		if t == framesTab {
+			aFrameBrowser.refresh()
+		}
+	}
 	window.SetContent(tabs)
 
 	refreshBackend()
@@ -103,24 +157,11 @@ func main() {
 func refreshBackend() {
 	aEncoder = encoder{}
 
-	if a.Preferences().String("backend") != "" && a.Preferences().String("backend") != "auto" {
-		switch a.Preferences().String("backend") {
-		case "ffmpeg":
-			aEncoder.setup(backendFFMPEG)
-		case "imagemagick":
-			aEncoder.setup(backendImageMagick)
-		case "apng":
-			aEncoder.setup(backendIntegrated)
-		}
+	if b := findBackend(a.Preferences().String("backend")); b != nil {
+		aEncoder.setup(b)
 	} else {
-		if aSettings.discoveredFFMPEGPath != "" {
-			aEncoder.setup(backendFFMPEG)
-		} else if aSettings.discoveredConvertPath != "" && aSettings.discoveredMagickPath != "" {
-			aEncoder.setup(backendImageMagick)
-		} else {
-			aEncoder.setup(backendIntegrated)
-		}
+		aEncoder.setup(nil)
 	}
-	tabs.Items[1].Content = container.NewPadded(aEncoder.container)
+	encodeTab.Content = container.NewPadded(container.NewVScroll(container.NewVBox(aEncoder.container, aQueue.container)))
 	tabs.Refresh()
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var normalizeModes = []string{"scale to first", "scale to largest", "center-pad"}
+
+// frameSizes summarizes the dimensions found in a set of frames.
+type frameSizes struct {
+	first   image.Point
+	largest image.Point
+	max     image.Point
+	counts  map[image.Point]int
+}
+
+// scanFrameSizes reads the header of every frame to find their dimensions.
+func scanFrameSizes(inpath string, files []string) (sizes frameSizes, err error) {
+	sizes.counts = make(map[image.Point]int)
+	for i, s := range files {
+		cfg, err := decodeImageConfig(filepath.Join(inpath, s))
+		if err != nil {
+			return sizes, err
+		}
+		p := image.Pt(cfg.Width, cfg.Height)
+		if i == 0 {
+			sizes.first = p
+		}
+		if p.X*p.Y > sizes.largest.X*sizes.largest.Y {
+			sizes.largest = p
+		}
+		if p.X > sizes.max.X {
+			sizes.max.X = p.X
+		}
+		if p.Y > sizes.max.Y {
+			sizes.max.Y = p.Y
+		}
+		sizes.counts[p]++
+	}
+	return sizes, nil
+}
+
+func (s frameSizes) mixed() bool {
+	return len(s.counts) > 1
+}
+
+// target returns the common canvas size for the given normalization mode.
+func (s frameSizes) target(mode string) image.Point {
+	switch mode {
+	case "scale to first":
+		return s.first
+	case "scale to largest":
+		return s.largest
+	}
+	return s.max
+}
+
+func (s frameSizes) String() string {
+	var sizes []image.Point
+	for p := range s.counts {
+		sizes = append(sizes, p)
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return s.counts[sizes[i]] > s.counts[sizes[j]]
+	})
+	var lines []string
+	for i, p := range sizes {
+		if i == 5 {
+			lines = append(lines, fmt.Sprintf("... and %d more sizes", len(sizes)-5))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%dx%d: %d frames", p.X, p.Y, s.counts[p]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeGeometry returns the geometry that brings a frame onto a target
+// canvas, either by fitting and letterboxing it or by centering it.
+func normalizeGeometry(mode string, target image.Point, padColor color.RGBA) frameGeometry {
+	g := frameGeometry{
+		scaleMode: "fit",
+		upscale:   true,
+		width:     target.X,
+		height:    target.Y,
+		pad:       true,
+		padColor:  padColor,
+	}
+	if mode == "center-pad" {
+		g.scaleMode = "none"
+	}
+	return g
+}
+
+// confirmNormalize asks how frames of differing sizes should be brought to a
+// common size before calling then.
+func (e *encoder) confirmNormalize(sizes frameSizes, then func()) {
+	modeCombo := widget.NewSelect(normalizeModes, nil)
+	modeCombo.SetSelected(e.normalizeMode)
+	content := container.NewVBox(
+		widget.NewLabel("The frames do not all share the same size:"),
+		widget.NewLabel(sizes.String()),
+		modeCombo,
+	)
+	dialog.ShowCustomConfirm("Mixed frame sizes", "Encode", "Cancel", content, func(ok bool) {
+		if !ok {
+			return
+		}
+		e.normalizeMode = modeCombo.Selected
+		a.Preferences().SetString("normalizeMode", e.normalizeMode)
+		if e.normalizeCombo != nil {
+			e.normalizeCombo.SetSelected(e.normalizeMode)
+		}
+		then()
+	}, window)
+}
+
+// stageFrames renders n frames from load into a temporary directory so that
+// external tools receive the same frames the integrated backend would.
+func (e *encoder) stageFrames(n int, load frameLoader) (dir string, staged []string, err error) {
+	if e.commands != nil {
+		// A preview only needs the names frames would be staged as.
+		for i := 0; i < n; i++ {
+			staged = append(staged, fmt.Sprintf("%08d.png", i))
+		}
+		return filepath.Join(os.TempDir(), "gosh-staged"), staged, nil
+	}
+	dir, err = os.MkdirTemp("", "gosh-")
+	if err != nil {
+		return "", nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	results := runOrdered(n, 0, done, func(i int) (string, error) {
+		m, err := load(i)
+		if err != nil {
+			return "", err
+		}
+		name := fmt.Sprintf("%08d.png", i)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return name, enc.Encode(f, m)
+	})
+	for r := range results {
+		if r.err != nil {
+			os.RemoveAll(dir)
+			return "", nil, r.err
+		}
+		staged = append(staged, r.value)
+		e.report(fmt.Sprintf("preparing %d/%d", len(staged), n))
+	}
+	return dir, staged, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestNormalizeGeometryFillsTarget(t *testing.T) {
+	target := image.Pt(200, 100)
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	g := normalizeGeometry("scale to largest", target, color.RGBA{A: 0xff})
+	l := g.layout(100, 50)
+	if l.size != target || l.scaled != target {
+		t.Fatalf("100x50 laid out as %v scaled to %v, want both %v", l.size, l.scaled, target)
+	}
+	dst := l.apply(src, g.padColor)
+	for _, p := range []image.Point{{0, 0}, {199, 0}, {0, 99}, {199, 99}} {
+		if c := dst.RGBAAt(p.X, p.Y); c.R != 0xff {
+			t.Errorf("pixel %v is %v, want the frame rather than padding", p, c)
+		}
+	}
+}
+
+func TestNormalizeGeometryCenterPad(t *testing.T) {
+	l := normalizeGeometry("center-pad", image.Pt(200, 100), color.RGBA{}).layout(100, 50)
+	if l.scaled != image.Pt(100, 50) || l.size != image.Pt(200, 100) || l.offset != image.Pt(50, 25) {
+		t.Fatalf("got %+v", l)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "runtime"
+
+type orderedResult[T any] struct {
+	value T
+	err   error
+}
+
+// runOrdered calls fn for every index in [0, n) on up to workers goroutines
+// and delivers the results in index order. At most workers results are held
+// ahead of the reader. Closing done stops any further work.
+func runOrdered[T any](n, workers int, done <-chan struct{}, fn func(i int) (T, error)) <-chan orderedResult[T] {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	out := make(chan orderedResult[T])
+	pending := make(chan chan orderedResult[T], workers)
+
+	go func() {
+		defer close(pending)
+		for i := 0; i < n; i++ {
+			c := make(chan orderedResult[T], 1)
+			select {
+			case pending <- c:
+			case <-done:
+				return
+			}
+			go func(i int) {
+				v, err := fn(i)
+				c <- orderedResult[T]{v, err}
+			}(i)
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		for c := range pending {
+			select {
+			case out <- <-c:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// shellQuote quotes s for a POSIX shell if it holds anything but plain
+// characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,:/@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// formatCommand writes a command line as it could be pasted into a shell.
+func formatCommand(bin string, args []string) string {
+	parts := []string{shellQuote(bin)}
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// previewedCommand is a command an encode would run.
+type previewedCommand struct {
+	dir  string
+	bin  string
+	args []string
+	// integrated names the file the integrated backend would write instead.
+	integrated string
+}
+
+func (c previewedCommand) String() string {
+	if c.integrated != "" {
+		return "(integrated) " + c.integrated
+	}
+	return "cd " + shellQuote(c.dir) + " && " + formatCommand(c.bin, c.args)
+}
+
+// template turns the command into a custom template writing to a file
+// ending in one of outputs, which becomes {output}.
+func (c previewedCommand) template(outputs []string) argTemplate {
+	t := argTemplate{Tool: c.bin}
+	switch c.bin {
+	case aSettings.getFFMPEGPath():
+		t.Tool = "ffmpeg"
+	case aSettings.getConvertPath():
+		t.Tool = "convert"
+	case aSettings.getMagickPath():
+		t.Tool = "magick"
+	}
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = templateQuote(arg)
+		for _, out := range outputs {
+			if strings.HasSuffix(arg, out) {
+				args[i] = strings.TrimSuffix(arg, out) + "{output}"
+				t.Ext = strings.TrimPrefix(filepath.Ext(out), ".")
+			}
+		}
+	}
+	t.Args = strings.Join(args, " ")
+	return t
+}
+
+// templateQuote quotes arg so splitArgs reads it back as one argument.
+func templateQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r'\"") {
+		return arg
+	} else if strings.Contains(arg, `"`) {
+		return "'" + arg + "'"
+	}
+	return `"` + arg + `"`
+}
+
+// previewCommands returns the commands an encode with the current settings
+// would run, without running them.
+func (e *encoder) previewCommands() ([]previewedCommand, error) {
+	job := e.currentJob()
+	var commands []previewedCommand
+	run := *e
+	run.commands = &commands
+	run.progress = func(string) {}
+	if err := run.encodeTo(job); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+func (e *encoder) setupPreview() *widget.AccordionItem {
+	commandInput := widget.NewMultiLineEntry()
+	commandInput.Wrapping = fyne.TextWrapWord
+	commandInput.SetPlaceHolder("Press Preview to show the commands the current settings run.")
+	commandInput.SetMinRowsVisible(6)
+
+	var previewed []previewedCommand
+	var saveButton *widget.Button
+	previewButton := widget.NewButtonWithIcon("Preview", theme.ViewRefreshIcon(), func() {
+		go func() {
+			commands, err := e.previewCommands()
+			if err != nil {
+				commandInput.SetText(err.Error())
+				return
+			}
+			previewed = commands
+			lines := make([]string, len(commands))
+			for i, c := range commands {
+				lines[i] = c.String()
+			}
+			commandInput.SetText(strings.Join(lines, "\n\n"))
+			saveButton.Enable()
+		}()
+	})
+	saveButton = widget.NewButtonWithIcon("Save as template", theme.DocumentSaveIcon(), func() {
+		job := e.currentJob()
+		var outputs []string
+		for _, kind := range job.kinds() {
+			outputs = append(outputs, outputFile(job.Output, kind))
+		}
+		for _, c := range previewed {
+			if c.integrated == "" && e.editTemplate != nil {
+				e.editTemplate(c.template(outputs))
+				return
+			}
+		}
+		dialog.ShowInformation("Save as template", "The integrated backend runs no command to save.", window)
+	})
+	saveButton.Disable()
+	copyButton := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+		window.Clipboard().SetContent(commandInput.Text)
+	})
+
+	return widget.NewAccordionItem("Command", container.NewVBox(
+		commandInput,
+		widget.NewLabel("{filelist} stands for the concat script written when encoding.\nSave as template opens the first command in Custom templates, to name and edit."),
+		container.NewCenter(container.NewHBox(previewButton, copyButton, saveButton)),
+	))
+}
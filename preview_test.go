@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreviewedCommandTemplate(t *testing.T) {
+	saved := aSettings.currentFFMPEGPath
+	defer func() { aSettings.currentFFMPEGPath = saved }()
+	aSettings.currentFFMPEGPath = "/opt/ffmpeg/bin/ffmpeg"
+
+	c := previewedCommand{
+		dir: "/frames",
+		bin: "/opt/ffmpeg/bin/ffmpeg",
+		args: []string{"-y", "-f", "concat", "-safe", "0", "-i", "{filelist}",
+			"-vf", "scale=640:-2, fps=30", "-metadata", `title=say "hi"`, "/out/my video.webm"},
+	}
+	tmpl := c.template([]string{"/out/my video.webm", "/out/my video.gif"})
+	if tmpl.Tool != "ffmpeg" || tmpl.Ext != "webm" {
+		t.Errorf("got tool %q and extension %q", tmpl.Tool, tmpl.Ext)
+	}
+	args, err := splitArgs(tmpl.Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]string{}, c.args[:len(c.args)-1]...), "{output}")
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("template args %q read back as %q, want %q", tmpl.Args, args, want)
+	}
+}
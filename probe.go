@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// toolCapabilities records what a configured ffmpeg or ImageMagick binary
+// reported it can write.
+type toolCapabilities struct {
+	path     string
+	version  string
+	err      error
+	encoders map[string]bool
+	muxers   map[string]bool
+	// formats holds the ImageMagick formats that can be written with more
+	// than one frame.
+	formats map[string]bool
+}
+
+// probed reports whether the binary answered every query.
+func (c toolCapabilities) probed() bool {
+	return c.path != "" && c.err == nil
+}
+
+func (c toolCapabilities) String() string {
+	if c.path == "" {
+		return "not found"
+	} else if c.err != nil {
+		return c.err.Error()
+	}
+	return c.version
+}
+
+// probeOutput runs the binary at path with args, returning its output.
+func probeOutput(path string, args ...string) ([]byte, error) {
+	out, err := exec.Command(path, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", path, strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// firstLine returns the first line of b, without any trailing copyright.
+func firstLine(b []byte) string {
+	line, _, _ := strings.Cut(string(b), "\n")
+	line, _, _ = strings.Cut(line, " Copyright")
+	return strings.TrimSpace(line)
+}
+
+// probeFFMPEG queries the version, encoders and muxers of the ffmpeg at path.
+func probeFFMPEG(path string) (c toolCapabilities) {
+	c.path = path
+	if path == "" {
+		return
+	}
+	out, err := probeOutput(path, "-version")
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.version = firstLine(out)
+	if out, err = probeOutput(path, "-hide_banner", "-encoders"); err != nil {
+		c.err = err
+		return
+	}
+	c.encoders = parseFFMPEGList(out)
+	if out, err = probeOutput(path, "-hide_banner", "-muxers"); err != nil {
+		c.err = err
+		return
+	}
+	c.muxers = parseFFMPEGList(out)
+	return
+}
+
+// parseFFMPEGList reads the names in the output of ffmpeg -encoders or
+// -muxers, which follow a line of dashes as flags then name.
+func parseFFMPEGList(b []byte) map[string]bool {
+	names := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if !listing {
+			listing = len(fields) > 0 && strings.Trim(fields[0], "-") == ""
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// probeMagick queries the version and writable formats of the ImageMagick
+// tool at path.
+func probeMagick(path string) (c toolCapabilities) {
+	c.path = path
+	if path == "" {
+		return
+	}
+	out, err := probeOutput(path, "-version")
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.version = strings.TrimPrefix(firstLine(out), "Version: ")
+	if out, err = probeOutput(path, "-list", "format"); err != nil {
+		c.err = err
+		return
+	}
+	c.formats = parseMagickFormats(out)
+	return
+}
+
+var magickModePattern = regexp.MustCompile(`^[r-][w-][+-]$`)
+
+// parseMagickFormats reads the output of -list format, where each format is
+// listed with a mode such as "rw+", w marking write support and + support for
+// several frames in one file.
+func parseMagickFormats(b []byte) map[string]bool {
+	formats := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// ImageMagick 7 adds a Module column before the mode.
+		for i := 1; i < len(fields) && i <= 2; i++ {
+			if mode := fields[i]; magickModePattern.MatchString(mode) {
+				if mode[1] == 'w' && mode[2] == '+' {
+					formats[strings.TrimSuffix(fields[0], "*")] = true
+				}
+				break
+			}
+		}
+	}
+	return formats
+}
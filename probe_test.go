@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+// ImageMagick 6, from convert -list format.
+const magick6Formats = `   Format  Mode  Description
+-------------------------------------------------------------------------------
+      3FR  r--   Hasselblad CFV/H3D39II
+     APNG* rw+   Animated Portable Network Graphics
+      BMP* rw-   Microsoft Windows bitmap image
+      GIF* rw+   CompuServe graphics interchange format
+    GIF87* rw-   CompuServe graphics interchange format (version 87a)
+      PNG* rw-   Portable Network Graphics (libpng 1.6.37)
+     WEBP* rw+   WebP Image Format (libwebp 1.0.3[0208])
+
+* native blob support
+r read support
+w write support
++ support for multiple images
+`
+
+// ImageMagick 7, from magick -list format.
+const magick7Formats = `   Format  Module    Mode  Description
+-------------------------------------------------------------------------------
+      3FR  DNG       r--   Hasselblad CFV/H3D39II Raw Format (0.21.2-Release)
+     APNG  PNG       rw+   Animated Portable Network Graphics
+      BMP* BMP       rw-   Microsoft Windows bitmap image
+      GIF* GIF       rw+   CompuServe graphics interchange format
+    GIF87* GIF       rw-   CompuServe graphics interchange format (version 87a)
+      PNG* PNG       rw-   Portable Network Graphics (libpng 1.6.43)
+     WEBP* WEBP      rw+   WebP Image Format (libwebp 1.3.2 [020F])
+
+* native blob support
+r read support
+w write support
++ support for multiple images
+`
+
+func TestParseMagickFormats(t *testing.T) {
+	for name, out := range map[string]string{"6": magick6Formats, "7": magick7Formats} {
+		formats := parseMagickFormats([]byte(out))
+		for _, f := range []string{"APNG", "GIF", "WEBP"} {
+			if !formats[f] {
+				t.Errorf("ImageMagick %s: %s not writable with several frames", name, f)
+			}
+		}
+		for _, f := range []string{"3FR", "BMP", "GIF87", "PNG", "Format"} {
+			if formats[f] {
+				t.Errorf("ImageMagick %s: %s should not be listed", name, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// A minimal decoder for the Quite OK Image format, see https://qoiformat.org.
+
+const qoiMagic = "qoif"
+
+func init() {
+	image.RegisterFormat("qoi", qoiMagic, decodeQOI, decodeQOIConfig)
+}
+
+func readQOIHeader(r io.Reader) (image.Config, error) {
+	var header [14]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return image.Config{}, err
+	}
+	if string(header[:4]) != qoiMagic {
+		return image.Config{}, errors.New("qoi: invalid header")
+	}
+	w := binary.BigEndian.Uint32(header[4:8])
+	h := binary.BigEndian.Uint32(header[8:12])
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > 1<<30 {
+		return image.Config{}, errors.New("qoi: invalid dimensions")
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: int(w), Height: int(h)}, nil
+}
+
+func decodeQOIConfig(r io.Reader) (image.Config, error) {
+	return readQOIHeader(r)
+}
+
+func decodeQOI(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	cfg, err := readQOIHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	m := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 255}
+	run := 0
+	for i := 0; i < len(m.Pix); i += 4 {
+		if run > 0 {
+			run--
+		} else {
+			b1, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case b1 == 0xfe:
+				if _, err := io.ReadFull(br, px[:3]); err != nil {
+					return nil, err
+				}
+			case b1 == 0xff:
+				if _, err := io.ReadFull(br, px[:4]); err != nil {
+					return nil, err
+				}
+			case b1>>6 == 0:
+				px = index[b1]
+			case b1>>6 == 1:
+				px[0] += (b1>>4)&3 - 2
+				px[1] += (b1>>2)&3 - 2
+				px[2] += b1&3 - 2
+			case b1>>6 == 2:
+				b2, err := br.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				dg := b1&0x3f - 32
+				px[0] += dg + (b2>>4)&0x0f - 8
+				px[1] += dg
+				px[2] += dg + b2&0x0f - 8
+			default:
+				run = int(b1 & 0x3f)
+			}
+			index[(int(px[0])*3+int(px[1])*5+int(px[2])*7+int(px[3])*11)%64] = px
+		}
+		copy(m.Pix[i:i+4], px[:])
+	}
+	return m, nil
+}
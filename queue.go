@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// encodeJob is a single encode, as run directly or from the queue.
+type encodeJob struct {
+	Input   string
+	Output  string // without extension
+	Kind    string // comma separated
+	FPS     float64
+	Backend string
+	Status  string
+	Log     string
+	// Produced records which backend wrote each type.
+	Produced string
+
+	// settings are those of the encoder when the job was queued. Jobs
+	// restored from a previous session run with the current ones.
+	settings *encoder
+
+	// written lists the output files, once encoded.
+	written []string
+	// frames lists the frames that were encoded, relative to Input.
+	frames   []string
+	progress string
+	mu       sync.Mutex
+}
+
+func (j *encodeJob) logf(format string, args ...interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Log += fmt.Sprintf(format, args...) + "\n"
+}
+
+// MarshalJSON saves the job while it may still be running.
+func (j *encodeJob) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return json.Marshal(struct {
+		Input, Output, Kind string
+		FPS                 float64
+		Backend             string
+		Status, Log         string
+		Produced            string
+	}{j.Input, j.Output, j.Kind, j.FPS, j.Backend, j.Status, j.Log, j.Produced})
+}
+
+func (j *encodeJob) status() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status
+}
+
+func (j *encodeJob) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = status
+}
+
+func (j *encodeJob) setProduced(produced string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Produced = produced
+}
+
+func (j *encodeJob) addOutputs(paths ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.written = append(j.written, paths...)
+}
+
+func (j *encodeJob) setFrames(frames []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.frames = frames
+}
+
+func (j *encodeJob) encodedFrames() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string{}, j.frames...)
+}
+
+func (j *encodeJob) outputs() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string{}, j.written...)
+}
+
+// kinds returns the output types of the job, which may hold several.
+func (j *encodeJob) kinds() []string {
+	if j.Kind == "" {
+		return nil
+	}
+	return strings.Split(j.Kind, ",")
+}
+
+func (j *encodeJob) String() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	backend := j.Backend
+	if j.Produced != "" {
+		backend = j.Produced
+	}
+	s := fmt.Sprintf("[%s] %s → %s (%s, %s fps)", j.Status, j.Input, outputName(j.Output, j.kinds()), backend, strconv.FormatFloat(j.FPS, 'f', -1, 64))
+	if j.Status == jobRunning && j.progress != "" {
+		s += ": " + j.progress
+	}
+	return s
+}
+
+// encodeQueue runs encode jobs one after another, or a few at a time.
+type encodeQueue struct {
+	container   *fyne.Container
+	list        *widget.List
+	startButton *widget.Button
+
+	mu       sync.Mutex
+	jobs     []*encodeJob
+	parallel int
+	running  int
+	active   bool
+}
+
+func (q *encodeQueue) setup() {
+	p := a.Preferences()
+
+	if s := p.String("encodeQueue"); s != "" {
+		if err := json.Unmarshal([]byte(s), &q.jobs); err != nil {
+			log.Println("Error loading encode queue", err)
+		}
+	}
+	for _, j := range q.jobs {
+		// Jobs interrupted by a restart start over.
+		if j.Status == jobRunning {
+			j.Status = jobQueued
+		}
+	}
+
+	q.list = widget.NewList(
+		func() int {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			return len(q.jobs)
+		},
+		func() fyne.CanvasObject {
+			return container.NewBorder(nil, nil, nil,
+				container.NewHBox(
+					widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), nil),
+					widget.NewButtonWithIcon("", theme.DocumentIcon(), nil),
+					widget.NewButtonWithIcon("", theme.DeleteIcon(), nil),
+				),
+				widget.NewLabel(""),
+			)
+		},
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			q.mu.Lock()
+			if id >= len(q.jobs) {
+				q.mu.Unlock()
+				return
+			}
+			job := q.jobs[id]
+			q.mu.Unlock()
+
+			c := o.(*fyne.Container)
+			c.Objects[0].(*widget.Label).SetText(job.String())
+			buttons := c.Objects[1].(*fyne.Container).Objects
+			retryButton := buttons[0].(*widget.Button)
+			logButton := buttons[1].(*widget.Button)
+			removeButton := buttons[2].(*widget.Button)
+
+			status := job.status()
+			retryButton.OnTapped = func() {
+				q.retry(job)
+			}
+			if status == jobFailed || status == jobDone {
+				retryButton.Enable()
+			} else {
+				retryButton.Disable()
+			}
+			logButton.OnTapped = func() {
+				q.showLog(job)
+			}
+			removeButton.OnTapped = func() {
+				q.remove(job)
+			}
+			if status == jobRunning {
+				removeButton.Disable()
+			} else {
+				removeButton.Enable()
+			}
+		},
+	)
+
+	parallelLabel := widget.NewLabel("Parallel jobs")
+	q.parallel = p.IntWithFallback("queueParallel", 1)
+	parallelInput := makeNumberEntry(q.parallel)
+	parallelInput.OnChanged = func(s string) {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			q.mu.Lock()
+			q.parallel = n
+			q.mu.Unlock()
+			p.SetInt("queueParallel", n)
+			q.schedule()
+		}
+	}
+
+	q.startButton = widget.NewButtonWithIcon("Start", theme.MediaPlayIcon(), func() {
+		q.mu.Lock()
+		active := q.active
+		q.mu.Unlock()
+		if active {
+			q.stop()
+		} else {
+			q.start()
+		}
+	})
+	clearButton := widget.NewButtonWithIcon("Clear finished", theme.ContentClearIcon(), func() {
+		q.clearFinished()
+	})
+
+	q.container = container.NewBorder(
+		container.NewVBox(
+			widget.NewSeparator(),
+			widget.NewLabel("Queue"),
+			container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), parallelLabel), nil, parallelInput),
+			container.NewCenter(container.NewHBox(q.startButton, clearButton)),
+		),
+		nil, nil, nil,
+		container.NewGridWrap(fyne.NewSize(0, 200), q.list),
+	)
+}
+
+// add appends a job to the queue.
+func (q *encodeQueue) add(job *encodeJob) {
+	job.setStatus(jobQueued)
+	q.mu.Lock()
+	q.jobs = append(q.jobs, job)
+	q.mu.Unlock()
+	q.save()
+	q.list.Refresh()
+	q.schedule()
+}
+
+func (q *encodeQueue) retry(job *encodeJob) {
+	job.mu.Lock()
+	job.Status = jobQueued
+	job.Log = ""
+	job.Produced = ""
+	job.written = nil
+	job.frames = nil
+	job.mu.Unlock()
+	q.save()
+	q.list.Refresh()
+	q.schedule()
+}
+
+func (q *encodeQueue) remove(job *encodeJob) {
+	q.mu.Lock()
+	for i, j := range q.jobs {
+		if j == job && j.status() != jobRunning {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	q.save()
+	q.list.Refresh()
+}
+
+func (q *encodeQueue) clearFinished() {
+	q.mu.Lock()
+	var jobs []*encodeJob
+	for _, j := range q.jobs {
+		if j.status() != jobDone {
+			jobs = append(jobs, j)
+		}
+	}
+	q.jobs = jobs
+	q.mu.Unlock()
+	q.save()
+	q.list.Refresh()
+}
+
+func (q *encodeQueue) start() {
+	q.mu.Lock()
+	q.active = true
+	q.mu.Unlock()
+	q.startButton.SetText("Stop")
+	q.startButton.SetIcon(theme.MediaStopIcon())
+	q.schedule()
+}
+
+// stop prevents further jobs from starting. Running jobs are left to finish.
+func (q *encodeQueue) stop() {
+	q.mu.Lock()
+	q.active = false
+	q.mu.Unlock()
+	q.startButton.SetText("Start")
+	q.startButton.SetIcon(theme.MediaPlayIcon())
+}
+
+// schedule starts queued jobs while there are free slots, stopping the
+// queue once everything has run.
+func (q *encodeQueue) schedule() {
+	q.mu.Lock()
+	if !q.active {
+		q.mu.Unlock()
+		return
+	}
+	pending := false
+	for _, j := range q.jobs {
+		if j.status() != jobQueued {
+			continue
+		}
+		if q.running >= q.parallel {
+			pending = true
+			break
+		}
+		j.setStatus(jobRunning)
+		q.running++
+		go q.run(j)
+	}
+	idle := q.running == 0 && !pending
+	q.mu.Unlock()
+
+	q.save()
+	q.list.Refresh()
+	if idle {
+		q.stop()
+	}
+}
+
+func (q *encodeQueue) run(job *encodeJob) {
+	// Each job gets its own copy of the encoder's settings so that
+	// concurrent jobs do not share per-run state.
+	e := aEncoder
+	if job.settings != nil {
+		e = *job.settings
+	}
+	e.progress = func(s string) {
+		job.mu.Lock()
+		job.progress = s
+		job.mu.Unlock()
+		q.list.Refresh()
+	}
+	var err error
+	e.backend = findBackend(job.Backend)
+	if e.backend == nil && job.Backend != "auto" {
+		err = fmt.Errorf("unknown backend %q", job.Backend)
+	} else {
+		err = e.encodeTo(job)
+	}
+
+	job.mu.Lock()
+	job.progress = ""
+	if err != nil {
+		job.Status = jobFailed
+		job.Log += err.Error() + "\n"
+	} else {
+		job.Status = jobDone
+	}
+	job.mu.Unlock()
+	if err == nil {
+		e.cleanupFrames(job)
+	}
+
+	q.mu.Lock()
+	q.running--
+	q.mu.Unlock()
+	q.schedule()
+}
+
+func (q *encodeQueue) save() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	b, err := json.Marshal(q.jobs)
+	if err != nil {
+		log.Println("Error saving encode queue", err)
+		return
+	}
+	a.Preferences().SetString("encodeQueue", string(b))
+}
+
+func (q *encodeQueue) showLog(job *encodeJob) {
+	job.mu.Lock()
+	text := job.Log
+	job.mu.Unlock()
+	if text == "" {
+		text = "(empty)"
+	}
+	grid := widget.NewTextGridFromString(text)
+	d := dialog.NewCustom(outputName(job.Output, job.kinds()), "Close", container.NewScroll(grid), window)
+	d.Resize(fyne.NewSize(600, 400))
+	d.Show()
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"image/png"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -25,6 +26,9 @@ type recorder struct {
 	displaysCombo                  *widget.Select
 	frequencyInput                 *widget.Entry
 	outInput                       *widget.Entry
+	liveCombo                      *widget.Select
+	liveFPSInput                   *widget.Entry
+	keepCheck                      *widget.Check
 	toggleButton                   *widget.Button
 	infoText                       *widget.RichText
 	areaX1, areaY1, areaX2, areaY2 *widget.Entry
@@ -36,6 +40,8 @@ type recorder struct {
 
 	writtenFrames int
 	writtenBytes  int64
+	// liveStatus describes the video being encoded live, if any.
+	liveStatus string
 }
 
 func (r *recorder) setup() {
@@ -119,6 +125,30 @@ func (r *recorder) setup() {
 	})
 	revealButton.Icon = theme.MailForwardIcon()
 
+	// Live encoding
+	liveLabel := widget.NewLabel("Live encode")
+	r.liveCombo = widget.NewSelect([]string{"off"}, func(value string) {
+		a.Preferences().SetString("recordLive", value)
+	})
+	r.liveCombo.SetSelected("off")
+
+	liveFPSLabel := widget.NewLabel("Live FPS")
+	r.liveFPSInput = widget.NewEntry()
+	r.liveFPSInput.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	r.liveFPSInput.SetText(a.Preferences().StringWithFallback("recordLiveFPS", "30"))
+	r.liveFPSInput.OnChanged = func(s string) {
+		a.Preferences().SetString("recordLiveFPS", s)
+	}
+
+	keepLabel := widget.NewLabel("Keep frames")
+	r.keepCheck = widget.NewCheck("Also write PNGs when encoding live", func(value bool) {
+		a.Preferences().SetBool("recordKeepFrames", value)
+	})
+	r.keepCheck.SetChecked(a.Preferences().Bool("recordKeepFrames"))
+
 	// Start/Stop
 	r.toggleButton = widget.NewButton("", func() {
 		r.startStop()
@@ -148,6 +178,9 @@ func (r *recorder) setup() {
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), outLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, outButton, revealButton), r.outInput),
 		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), liveLabel), nil, r.liveCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), liveFPSLabel), nil, r.liveFPSInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), keepLabel), nil, r.keepCheck),
 		container.NewCenter(r.toggleButton),
 		container.NewCenter(r.infoText),
 	)
@@ -170,8 +203,24 @@ func (r *recorder) refreshDisplays() {
 	r.displaysCombo.Options = displayNames
 }
 
+// refreshLiveKinds offers the output types the probed ffmpeg can stream into.
+func (r *recorder) refreshLiveKinds() {
+	kind := a.Preferences().StringWithFallback("recordLive", "off")
+	r.liveCombo.Options = append([]string{"off"}, liveKinds()...)
+	if hasKind(r.liveCombo.Options, kind) {
+		r.liveCombo.SetSelected(kind)
+	} else {
+		r.liveCombo.Selected = "off"
+		r.liveCombo.Refresh()
+	}
+}
+
 func (r *recorder) refreshInfo() {
-	r.infoText.ParseMarkdown(fmt.Sprintf("**%d** frames\n\n**%.2f** MB", r.writtenFrames, float64(r.writtenBytes)/1024/1024))
+	info := fmt.Sprintf("**%d** frames\n\n**%.2f** MB", r.writtenFrames, float64(r.writtenBytes)/1024/1024)
+	if r.liveStatus != "" {
+		info += "\n\n" + r.liveStatus
+	}
+	r.infoText.ParseMarkdown(info)
 }
 
 func (r *recorder) setArea(x1, y1, x2, y2 int) {
@@ -204,6 +253,7 @@ func (r *recorder) start() {
 	y2, _ := strconv.ParseInt(r.areaY2.Text, 10, 64)
 	r.writtenBytes = 0
 	r.writtenFrames = 0
+	r.liveStatus = ""
 	r.refreshInfo()
 	seconds, err := strconv.ParseFloat(r.frequencyInput.Text, 64)
 	if err != nil {
@@ -214,10 +264,73 @@ func (r *recorder) start() {
 
 	out := r.outInput.Text
 
+	// Frames are streamed into ffmpeg when live encoding, falling back to
+	// PNGs should it fail.
+	var live *liveEncoder
+	var liveBytes int64
+	keep := true
+	kind := r.liveCombo.Selected
+	segments := aEncoder.segments
+	startLive := func() {
+		fps, _ := strconv.ParseFloat(r.liveFPSInput.Text, 64)
+		if fps <= 0 {
+			fps = 30
+		}
+		p := outputFile(filepath.Join(out, strconv.FormatInt(time.Now().UnixMilli(), 10)), kind)
+		var split *liveSplit
+		if segments.active() {
+			// Segments are named like those of an encode to the Encode
+			// tab's output.
+			base := aEncoder.outputPath
+			if base == "" {
+				base = filepath.Join(out, "recording")
+			}
+			switch segments.mode {
+			case "size":
+				p = outputFile(segments.segmentOutput(base, time.Now(), []string{kind}, map[string]bool{}), kind)
+			case "duration":
+				// Segments begin on the clock, as when encoding, within
+				// a hundredth of their length.
+				keyframes := math.Max(1, math.Floor(segments.duration.Seconds()/100/seconds))
+				split = &liveSplit{length: segments.duration.Seconds(), clock: true, keyframes: keyframes / fps}
+				p = outputFile(base+"-"+segments.name, kind)
+			case "frames":
+				length := float64(segments.frames) / fps
+				split = &liveSplit{length: length, keyframes: length}
+				p = outputFile(base+"-"+segments.name, kind)
+			}
+		}
+		var err error
+		live, err = startLiveEncoder(kind, image.Pt(int(x2), int(y2)), fps, p, split)
+		liveBytes = 0
+		if err != nil {
+			log.Println("Error starting live encoding, writing frames instead", err)
+			live = nil
+			keep = true
+			r.liveStatus = "live encoding failed, writing frames"
+		} else {
+			keep = r.keepCheck.Checked
+			r.liveStatus = "encoding " + p
+		}
+		r.refreshInfo()
+	}
+	if kind != "" && kind != "off" {
+		startLive()
+	}
+
 	go func() {
 		for {
 			select {
 			case <-r.stopChan:
+				if live != nil {
+					if err := live.close(); err != nil {
+						log.Println("Error finishing live encoding", err)
+						r.liveStatus = "live encoding failed"
+					} else {
+						r.liveStatus = "wrote " + live.path
+					}
+					r.refreshInfo()
+				}
 				return
 			case <-time.After(time.Millisecond * t):
 				img, err := screenshot.CaptureRect(image.Rect(int(x1), int(y1), int(x1+x2), int(y1+y2)))
@@ -225,18 +338,40 @@ func (r *recorder) start() {
 					panic(err)
 				}
 
-				p := filepath.Join(out, fmt.Sprintf("%d.png", time.Now().UnixMilli()))
-				f, err := os.Create(p)
-				if err != nil {
-					panic(err)
+				if live != nil {
+					if err := live.write(img); err != nil {
+						log.Println("Error in live encoding, writing frames instead", err)
+						live.close()
+						live = nil
+						keep = true
+						r.liveStatus = "live encoding failed, writing frames"
+					} else {
+						n := live.written()
+						r.writtenBytes += n - liveBytes
+						liveBytes = n
+					}
 				}
-				png.Encode(f, img)
-				s, err := f.Stat()
-				if err != nil {
-					panic(err)
+				if live != nil && segments.active() && segments.mode == "size" && liveBytes >= segments.bytes() {
+					if err := live.close(); err != nil {
+						log.Println("Error finishing live encoding segment", err)
+					}
+					startLive()
+				}
+
+				if keep {
+					p := filepath.Join(out, fmt.Sprintf("%d.png", time.Now().UnixMilli()))
+					f, err := os.Create(p)
+					if err != nil {
+						panic(err)
+					}
+					png.Encode(f, img)
+					s, err := f.Stat()
+					if err != nil {
+						panic(err)
+					}
+					f.Close()
+					r.writtenBytes += s.Size()
 				}
-				f.Close()
-				r.writtenBytes += s.Size()
 				r.writtenFrames++
 				r.refreshInfo()
 			}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var segmentModes = []string{"off", "duration", "frames", "size"}
+
+// sizeProbeFrames are encoded to estimate how many frames fit a segment of
+// a size.
+const sizeProbeFrames = 30
+
+// segmentation splits long recordings into several outputs, both when
+// encoding and when encoding live while recording.
+type segmentation struct {
+	mode string
+	// duration is the capture time each segment covers.
+	duration  time.Duration
+	frames    int
+	megabytes float64
+	// name is a strftime template, such as "%Y-%m-%d_%H", naming each
+	// segment by the time it starts.
+	name string
+}
+
+func (s segmentation) active() bool {
+	switch s.mode {
+	case "duration":
+		return s.duration > 0
+	case "frames":
+		return s.frames > 0
+	case "size":
+		return s.megabytes > 0
+	}
+	return false
+}
+
+func (s segmentation) bytes() int64 {
+	return int64(s.megabytes * 1024 * 1024)
+}
+
+// strftimeLayouts maps the strftime conversions segment names may use to Go
+// time layouts.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'b': "Jan",
+	'a': "Mon",
+}
+
+// strftime formats t with the subset of strftime conversions ffmpeg's segment
+// muxer shares with it, so live and encoded segments are named alike.
+func strftime(layout string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i == len(layout)-1 {
+			b.WriteByte(layout[i])
+			continue
+		}
+		i++
+		if l, ok := strftimeLayouts[layout[i]]; ok {
+			b.WriteString(t.Format(l))
+		} else if layout[i] == 'j' {
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		} else if layout[i] == '%' {
+			b.WriteByte('%')
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(layout[i])
+		}
+	}
+	return b.String()
+}
+
+// window returns the start of the duration segment t falls in. Segments of a
+// day or less are counted from local midnight, so hourly ones begin on the
+// hour.
+func (s segmentation) window(t time.Time) time.Time {
+	if s.duration > 24*time.Hour {
+		return t.Truncate(s.duration)
+	}
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	return midnight.Add(t.Sub(midnight).Truncate(s.duration))
+}
+
+// split divides files by duration or frame count, returning each segment
+// along with the time that names it.
+func (s segmentation) split(dir string, files []string) (segments [][]string, starts []time.Time) {
+	for i, f := range files {
+		t := frameTime(dir, f)
+		switch s.mode {
+		case "duration":
+			if w := s.window(t); len(starts) == 0 || !w.Equal(starts[len(starts)-1]) {
+				segments = append(segments, nil)
+				starts = append(starts, w)
+			}
+		case "frames":
+			if i%s.frames == 0 {
+				segments = append(segments, nil)
+				starts = append(starts, t)
+			}
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], f)
+	}
+	return
+}
+
+// segmentOutput names a segment of output starting at t. A number is added
+// should the name be taken, by an earlier segment or an existing file.
+func (s segmentation) segmentOutput(output string, t time.Time, kinds []string, used map[string]bool) string {
+	base := output + "-" + strftime(s.name, t)
+	name := base
+	for n := 2; ; n++ {
+		taken := used[name]
+		for _, kind := range kinds {
+			if _, err := os.Stat(outputFile(name, kind)); err == nil {
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
+		name = base + "-" + strconv.Itoa(n)
+	}
+	used[name] = true
+	return name
+}
+
+// segmentBytes returns the size of the largest output of segment.
+func segmentBytes(segment *encodeJob) (largest int64) {
+	for _, kind := range segment.kinds() {
+		if st, err := os.Stat(outputFile(segment.Output, kind)); err == nil && st.Size() > largest {
+			largest = st.Size()
+		}
+	}
+	return
+}
+
+// encodeSegments encodes job as a series of segments, each to its own
+// outputs.
+func (e *encoder) encodeSegments(job *encodeJob) error {
+	files, err := e.listFrames(job.Input)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	var produced []string
+	encode := func(frames []string, start time.Time) (*encodeJob, error) {
+		segment := &encodeJob{
+			Input:   job.Input,
+			Output:  e.segments.segmentOutput(job.Output, start, job.kinds(), used),
+			Kind:    job.Kind,
+			FPS:     job.FPS,
+			Backend: job.Backend,
+		}
+		run := *e
+		run.frames = frames
+		err := run.encodeTo(segment)
+		job.logf("%s", strings.TrimSuffix(segment.Log, "\n"))
+		return segment, err
+	}
+	finish := func(segment *encodeJob) {
+		produced = append(produced, outputName(segment.Output, segment.kinds()))
+		job.addOutputs(segment.outputs()...)
+		job.setProduced(fmt.Sprintf("%d segments by %s", len(produced), segment.Produced))
+	}
+
+	if e.segments.mode != "size" {
+		segments, starts := e.segments.split(job.Input, files)
+		for i, frames := range segments {
+			e.report(fmt.Sprintf("segment %d of %d", i+1, len(segments)))
+			segment, err := encode(frames, starts[i])
+			if err != nil {
+				return fmt.Errorf("segment %d: %w", i+1, err)
+			}
+			finish(segment)
+		}
+		e.report("complete: " + strings.Join(produced, ", "))
+		return nil
+	}
+
+	// Sizes are only known once encoded. The length of the first segment is
+	// estimated from a short probe encode, that of later ones from the bytes
+	// per frame of the last, and a segment is re-encoded with fewer frames
+	// should it still not fit.
+	var perFrame float64
+	var n int
+	for start := 0; start < len(files); start += n {
+		remaining := len(files) - start
+		if perFrame == 0 && e.commands == nil {
+			probe := sizeProbeFrames
+			if probe > remaining {
+				probe = remaining
+			}
+			e.report(fmt.Sprintf("segment %d, probing %d frames", len(produced)+1, probe))
+			segment, err := encode(files[start:start+probe], frameTime(job.Input, files[start]))
+			if err != nil {
+				return fmt.Errorf("segment %d: %w", len(produced)+1, err)
+			}
+			perFrame = float64(segmentBytes(segment)) / float64(probe)
+			for _, kind := range segment.kinds() {
+				os.Remove(outputFile(segment.Output, kind))
+			}
+			delete(used, segment.Output)
+		}
+		n = remaining
+		if perFrame > 0 {
+			n = int(float64(e.segments.bytes()) / perFrame * 0.95)
+		}
+		if n > remaining {
+			n = remaining
+		} else if n < 1 {
+			n = 1
+		}
+		for attempt := 0; ; attempt++ {
+			e.report(fmt.Sprintf("segment %d, %d frames", len(produced)+1, n))
+			frames := files[start : start+n]
+			segment, err := encode(frames, frameTime(job.Input, frames[0]))
+			if err != nil {
+				return fmt.Errorf("segment %d: %w", len(produced)+1, err)
+			}
+			largest := segmentBytes(segment)
+			if largest <= e.segments.bytes() || n == 1 || e.commands != nil {
+				perFrame = float64(largest) / float64(n)
+				finish(segment)
+				break
+			}
+			if attempt == maxFitAttempts {
+				return fmt.Errorf("segment %d is still %.2f MB, above %s MB", len(produced)+1, float64(largest)/1024/1024, strconv.FormatFloat(e.segments.megabytes, 'f', -1, 64))
+			}
+			for _, kind := range segment.kinds() {
+				os.Remove(outputFile(segment.Output, kind))
+			}
+			delete(used, segment.Output)
+			fewer := int(float64(n) * float64(e.segments.bytes()) / float64(largest) * 0.95)
+			if fewer >= n {
+				fewer = n - 1
+			}
+			if fewer < 1 {
+				fewer = 1
+			}
+			n = fewer
+		}
+	}
+	e.report("complete: " + strings.Join(produced, ", "))
+	return nil
+}
+
+func (e *encoder) setupSegments() *widget.AccordionItem {
+	p := a.Preferences()
+	s := &e.segments
+
+	durationLabel := widget.NewLabel("Segment length")
+	durationInput := widget.NewEntry()
+	durationInput.SetPlaceHolder("1h, 24h")
+	durationInput.SetText(p.StringWithFallback("segmentDuration", "1h"))
+	durationInput.Validator = func(v string) error {
+		_, err := parseLength(v)
+		return err
+	}
+	s.duration, _ = parseLength(durationInput.Text)
+	durationInput.OnChanged = func(v string) {
+		if d, err := parseLength(v); err == nil && d >= 0 {
+			s.duration = d
+			p.SetString("segmentDuration", v)
+		}
+	}
+
+	framesLabel := widget.NewLabel("Segment frames")
+	s.frames = p.IntWithFallback("segmentFrames", 1000)
+	framesInput := makeNumberEntry(s.frames)
+	framesInput.OnChanged = func(v string) {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			s.frames = n
+			p.SetInt("segmentFrames", n)
+		}
+	}
+
+	sizeLabel := widget.NewLabel("Segment size (MB)")
+	s.megabytes = p.FloatWithFallback("segmentSize", 100)
+	sizeInput := widget.NewEntry()
+	sizeInput.SetText(strconv.FormatFloat(s.megabytes, 'f', -1, 64))
+	sizeInput.Validator = func(v string) error {
+		_, err := strconv.ParseFloat(v, 64)
+		return err
+	}
+	sizeInput.OnChanged = func(v string) {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			s.megabytes = f
+			p.SetFloat("segmentSize", f)
+		}
+	}
+
+	nameLabel := widget.NewLabel("Segment names")
+	nameInput := widget.NewEntry()
+	nameInput.SetText(p.StringWithFallback("segmentName", "%Y-%m-%d_%H-%M-%S"))
+	s.name = nameInput.Text
+	nameInput.OnChanged = func(v string) {
+		s.name = v
+		p.SetString("segmentName", v)
+	}
+
+	modeLabel := widget.NewLabel("Split by")
+	modeCombo := widget.NewSelect(segmentModes, func(value string) {
+		s.mode = value
+		p.SetString("segmentMode", value)
+		for input, mode := range map[fyne.Disableable]string{durationInput: "duration", framesInput: "frames", sizeInput: "size"} {
+			if value == mode {
+				input.Enable()
+			} else {
+				input.Disable()
+			}
+		}
+		if value == "off" {
+			nameInput.Disable()
+		} else {
+			nameInput.Enable()
+		}
+	})
+	modeCombo.SetSelected(p.StringWithFallback("segmentMode", "off"))
+
+	return widget.NewAccordionItem("Segments", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), modeLabel), nil, modeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), durationLabel), nil, durationInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), framesLabel), nil, framesInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), sizeLabel), nil, sizeInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), nameLabel), nil, nameInput),
+		widget.NewLabel("Each segment is written to the output name followed by its start time,\nformatted with %Y %m %d %H %M %S. Segments also apply to live encoding\nwhile recording, where lengths are in capture time."),
+	))
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os/exec"
+	"strings"
+	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -19,18 +21,54 @@ type settings struct {
 	currentFFMPEGPath     string
 	currentConvertPath    string
 	currentMagickPath     string
+
+	ffmpegCaps  toolCapabilities
+	convertCaps toolCapabilities
+	magickCaps  toolCapabilities
+
+	ffmpegInfo  *widget.Label
+	convertInfo *widget.Label
+	magickInfo  *widget.Label
+
+	// probing runs one probe at a time, so the last path asked for wins.
+	probing sync.Mutex
 }
 
 func (s *settings) setup() {
 	setup := false
+	orderLabel := widget.NewLabel("Auto order")
+	orderInput := widget.NewEntry()
+	orderInput.SetPlaceHolder(strings.Join(backendNames(), ", "))
+	orderInput.SetText(a.Preferences().String("backendOrder"))
+	orderInput.Validator = func(value string) error {
+		_, err := parseBackendOrder(value)
+		return err
+	}
+	orderInput.OnChanged = func(value string) {
+		if _, err := parseBackendOrder(value); err == nil {
+			a.Preferences().SetString("backendOrder", value)
+			refreshBackend()
+		}
+	}
+
 	backendsLabel := widget.NewLabel("Backend")
-	s.backendsCombo = widget.NewSelect([]string{"auto", "ffmpeg", "imagemagick", "apng"}, func(value string) {
+	s.backendsCombo = widget.NewSelect(append([]string{"auto"}, backendNames()...), func(value string) {
 		a.Preferences().SetString("backend", value)
+		if value == "auto" {
+			orderInput.Enable()
+		} else {
+			orderInput.Disable()
+		}
 		if setup {
 			refreshBackend()
 		}
 	})
-	s.backendsCombo.SetSelected(a.Preferences().StringWithFallback("backend", "auto"))
+	selected := a.Preferences().StringWithFallback("backend", "auto")
+	if selected == "apng" {
+		// The integrated backend used to be called apng.
+		selected = "integrated"
+	}
+	s.backendsCombo.SetSelected(selected)
 
 	ffmpegPathLabel := widget.NewLabel("ffmpeg path")
 	ffmpegPathInput := widget.NewEntry()
@@ -43,7 +81,9 @@ func (s *settings) setup() {
 	ffmpegPathInput.OnChanged = func(value string) {
 		a.Preferences().SetString("ffmpegPath", value)
 		s.currentFFMPEGPath = value
-		refreshBackend()
+	}
+	ffmpegPathInput.OnSubmitted = func(string) {
+		s.probeFFMPEG()
 	}
 	ffmpegPathFileOpen := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
 		if err != nil {
@@ -53,6 +93,7 @@ func (s *settings) setup() {
 		}
 		uc.Close()
 		ffmpegPathInput.SetText(uc.URI().Path())
+		s.probeFFMPEG()
 	}, window)
 	ffmpegPathButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
 		ffmpegPathFileOpen.Show()
@@ -62,6 +103,7 @@ func (s *settings) setup() {
 			s.discoveredFFMPEGPath = p
 			ffmpegPathInput.SetPlaceHolder(p)
 		}
+		s.probeFFMPEG()
 	})
 
 	convertPathLabel := widget.NewLabel("convert path")
@@ -75,7 +117,9 @@ func (s *settings) setup() {
 	convertPathInput.OnChanged = func(value string) {
 		a.Preferences().SetString("convertPath", value)
 		s.currentConvertPath = value
-		refreshBackend()
+	}
+	convertPathInput.OnSubmitted = func(string) {
+		s.probeConvert()
 	}
 	convertPathFileOpen := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
 		if err != nil {
@@ -85,6 +129,7 @@ func (s *settings) setup() {
 		}
 		uc.Close()
 		convertPathInput.SetText(uc.URI().Path())
+		s.probeConvert()
 	}, window)
 	convertPathButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
 		convertPathFileOpen.Show()
@@ -94,6 +139,7 @@ func (s *settings) setup() {
 			s.discoveredConvertPath = p
 			convertPathInput.SetPlaceHolder(p)
 		}
+		s.probeConvert()
 	})
 
 	magickPathLabel := widget.NewLabel("magick path")
@@ -107,7 +153,9 @@ func (s *settings) setup() {
 	magickPathInput.OnChanged = func(value string) {
 		a.Preferences().SetString("magickPath", value)
 		s.currentMagickPath = value
-		refreshBackend()
+	}
+	magickPathInput.OnSubmitted = func(string) {
+		s.probeMagick()
 	}
 	magickPathFileOpen := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
 		if err != nil {
@@ -117,6 +165,7 @@ func (s *settings) setup() {
 		}
 		uc.Close()
 		magickPathInput.SetText(uc.URI().Path())
+		s.probeMagick()
 	}, window)
 	magickPathButton := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
 		magickPathFileOpen.Show()
@@ -126,8 +175,23 @@ func (s *settings) setup() {
 			s.discoveredMagickPath = p
 			magickPathInput.SetPlaceHolder(p)
 		}
+		s.probeMagick()
 	})
 
+	s.ffmpegInfo = widget.NewLabel("")
+	s.convertInfo = widget.NewLabel("")
+	s.magickInfo = widget.NewLabel("")
+	for _, l := range []*widget.Label{s.ffmpegInfo, s.convertInfo, s.magickInfo} {
+		l.Wrapping = fyne.TextWrapWord
+	}
+	s.ffmpegCaps = probeFFMPEG(s.getFFMPEGPath())
+	s.ffmpegInfo.SetText(describeFFMPEG(s.ffmpegCaps))
+	aRecorder.refreshLiveKinds()
+	s.convertCaps = probeMagick(s.getConvertPath())
+	s.convertInfo.SetText(describeMagick(s.convertCaps))
+	s.magickCaps = probeMagick(s.getMagickPath())
+	s.magickInfo.SetText(describeMagick(s.magickCaps))
+
 	swapFFMPEGFramerateLabel := widget.NewLabel("Swap FFMPEG framerate")
 	swapFFMPEGFramerateCheck := widget.NewCheck("", func(value bool) {
 		aEncoder.swapFFMPEGFramerate = value
@@ -140,15 +204,19 @@ func (s *settings) setup() {
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), backendsLabel), nil,
 			container.NewBorder(nil, nil, nil, nil, s.backendsCombo),
 		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), orderLabel), nil, orderInput),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), ffmpegPathLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, ffmpegPathButton, ffmpegRefreshButton), ffmpegPathInput),
 		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0)), nil, s.ffmpegInfo),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), convertPathLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, convertPathButton, convertRefreshButton), convertPathInput),
 		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0)), nil, s.convertInfo),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), magickPathLabel), nil,
 			container.NewBorder(nil, nil, nil, container.NewAdaptiveGrid(2, magickPathButton, magickRefreshButton), magickPathInput),
 		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0)), nil, s.magickInfo),
 		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), swapFFMPEGFramerateLabel), nil, swapFFMPEGFramerateCheck),
 		container.NewBorder(nil, nil, nil, nil, swapFFMPEGFramerateInfo),
 	)
@@ -175,3 +243,40 @@ func (s *settings) getMagickPath() string {
 	}
 	return s.currentMagickPath
 }
+
+// probe runs the probe of a tool at path in the background, then shows what
+// it found in info and rebuilds the Encode tab. Tools are run as typed only
+// once the path is submitted, picked or refreshed.
+func (s *settings) probe(path string, info *widget.Label, probe func(path string) toolCapabilities, done func(c toolCapabilities)) {
+	info.SetText("Checking " + path + "...")
+	go func() {
+		s.probing.Lock()
+		defer s.probing.Unlock()
+		c := probe(path)
+		done(c)
+		refreshBackend()
+	}()
+}
+
+// probeFFMPEG asks the configured ffmpeg what it can write.
+func (s *settings) probeFFMPEG() {
+	s.probe(s.getFFMPEGPath(), s.ffmpegInfo, probeFFMPEG, func(c toolCapabilities) {
+		s.ffmpegCaps = c
+		s.ffmpegInfo.SetText(describeFFMPEG(c))
+		aRecorder.refreshLiveKinds()
+	})
+}
+
+func (s *settings) probeConvert() {
+	s.probe(s.getConvertPath(), s.convertInfo, probeMagick, func(c toolCapabilities) {
+		s.convertCaps = c
+		s.convertInfo.SetText(describeMagick(c))
+	})
+}
+
+func (s *settings) probeMagick() {
+	s.probe(s.getMagickPath(), s.magickInfo, probeMagick, func(c toolCapabilities) {
+		s.magickCaps = c
+		s.magickInfo.SetText(describeMagick(c))
+	})
+}
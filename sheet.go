@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var sheetSelections = []string{"even", "most change"}
+var sheetFormats = []string{"png", "jpeg"}
+
+// contactSheet lays out a sample of frames in a grid, as a single image.
+type contactSheet struct {
+	count      int
+	columns    int
+	thumbWidth int
+	spacing    int
+	selection  string
+	header     string
+	format     string
+}
+
+// pickEven returns count indexes spread evenly over n frames.
+func pickEven(n, count int) []int {
+	if count >= n {
+		count = n
+	}
+	if count == 1 {
+		return []int{0}
+	}
+	picked := make([]int, count)
+	for i := range picked {
+		picked[i] = int(math.Round(float64(i) * float64(n-1) / float64(count-1)))
+	}
+	return picked
+}
+
+// pickChanged returns the first frame and the count-1 frames that differ most
+// from the frame before them, in order.
+func pickChanged(change []float64, count int) []int {
+	order := make([]int, len(change)-1)
+	for i := range order {
+		order[i] = i + 1
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return change[order[i]] > change[order[j]]
+	})
+	if count-1 < len(order) {
+		order = order[:count-1]
+	}
+	picked := append([]int{0}, order...)
+	sort.Ints(picked)
+	return picked
+}
+
+// frameChange returns the mean difference per channel between a and b.
+func frameChange(a, b *image.RGBA) float64 {
+	if a.Rect != b.Rect {
+		return math.MaxFloat64
+	}
+	var sum int
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return float64(sum) / float64(len(a.Pix))
+}
+
+// exportContactSheet writes a contact sheet of the frames in inpath to
+// outpath, returning the path written.
+func (e *encoder) exportContactSheet(inpath, outpath string) (string, error) {
+	c := e.sheet
+	if c.count < 1 || c.columns < 1 || c.thumbWidth < 1 {
+		return "", fmt.Errorf("invalid contact sheet settings")
+	}
+	files, err := e.listFrames(inpath)
+	if err != nil {
+		return "", err
+	}
+	// Frames are shown whole rather than normalized to a common size.
+	e.normalizeTarget = image.Point{}
+	done := make(chan struct{})
+	defer close(done)
+
+	var picked []int
+	if c.selection == "most change" && len(files) > 1 {
+		results := runOrdered(len(files), 0, done, func(i int) (*image.RGBA, error) {
+			m, err := decodeImage(filepath.Join(inpath, files[i]))
+			if err != nil {
+				return nil, err
+			}
+			return toRGBA(thumbnailImage(m, 64, 64)), nil
+		})
+		change := make([]float64, len(files))
+		var prev *image.RGBA
+		i := 0
+		for r := range results {
+			if r.err != nil {
+				return "", r.err
+			}
+			e.report(fmt.Sprintf("comparing %d/%d", i+1, len(files)))
+			if prev != nil {
+				change[i] = frameChange(prev, r.value)
+			}
+			prev = r.value
+			i++
+		}
+		picked = pickChanged(change, c.count)
+	} else {
+		picked = pickEven(len(files), c.count)
+	}
+
+	cfg, err := decodeImageConfig(filepath.Join(inpath, files[picked[0]]))
+	if err != nil {
+		return "", err
+	}
+	size := e.geometry.layout(cfg.Width, cfg.Height).size
+	cellW := c.thumbWidth
+	cellH := int(math.Max(1, math.Round(float64(cellW)*float64(size.Y)/float64(size.X))))
+	labelSize := math.Max(10, float64(cellW)/16)
+	labelFace, err := textFace(labelSize)
+	if err != nil {
+		return "", err
+	}
+	defer labelFace.Close()
+	labelH := labelFace.Metrics().Height.Ceil() + c.spacing/2
+
+	headerH := 0
+	var headerFace font.Face
+	if c.header != "" {
+		if headerFace, err = textFace(labelSize * 2); err != nil {
+			return "", err
+		}
+		defer headerFace.Close()
+		headerH = headerFace.Metrics().Height.Ceil() + c.spacing
+	}
+
+	columns := c.columns
+	if columns > len(picked) {
+		columns = len(picked)
+	}
+	rows := (len(picked) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		columns*cellW+(columns+1)*c.spacing,
+		headerH+rows*(cellH+labelH)+(rows+1)*c.spacing,
+	))
+	draw.Draw(sheet, sheet.Rect, image.NewUniform(e.text.bg), image.Point{}, draw.Src)
+	fg := image.NewUniform(e.text.fg)
+
+	if headerFace != nil {
+		d := font.Drawer{Dst: sheet, Src: fg, Face: headerFace}
+		d.Dot = fixed.P(c.spacing, c.spacing).Add(fixed.Point26_6{Y: headerFace.Metrics().Ascent})
+		d.DrawString(c.header)
+	}
+
+	results := runOrdered(len(picked), 0, done, func(i int) (image.Image, error) {
+		m, err := e.loadFrame(filepath.Join(inpath, files[picked[i]]))
+		if err != nil {
+			return nil, err
+		}
+		return thumbnailImage(m, cellW, cellH), nil
+	})
+	i := 0
+	for r := range results {
+		if r.err != nil {
+			return "", r.err
+		}
+		e.report(fmt.Sprintf("drawing %d/%d", i+1, len(picked)))
+		cell := image.Rect(0, 0, cellW, cellH).Add(image.Pt(
+			c.spacing+(i%columns)*(cellW+c.spacing),
+			headerH+c.spacing+(i/columns)*(cellH+labelH+c.spacing),
+		))
+		thumb := r.value.Bounds()
+		at := cell.Min.Add(cell.Size().Sub(thumb.Size()).Div(2))
+		draw.Draw(sheet, thumb.Add(at), r.value, thumb.Min, draw.Src)
+
+		label := frameTime(inpath, files[picked[i]]).Format("2006-01-02 15:04:05")
+		d := font.Drawer{Dst: sheet, Src: fg, Face: labelFace}
+		d.Dot = fixed.Point26_6{
+			X: fixed.I(cell.Min.X) + (fixed.I(cellW)-d.MeasureString(label))/2,
+			Y: fixed.I(cell.Max.Y+c.spacing/2) + labelFace.Metrics().Ascent,
+		}
+		d.DrawString(label)
+		i++
+	}
+
+	ext := ".png"
+	if c.format == "jpeg" {
+		ext = ".jpg"
+	}
+	outpath += "-sheet" + ext
+	out, err := os.Create(outpath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if c.format == "jpeg" {
+		err = jpeg.Encode(out, sheet, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(out, sheet)
+	}
+	if err != nil {
+		return "", err
+	}
+	return outpath, out.Close()
+}
+
+func (e *encoder) setupSheet() *widget.AccordionItem {
+	p := a.Preferences()
+	c := &e.sheet
+
+	selectionLabel := widget.NewLabel("Frames")
+	selectionCombo := widget.NewSelect(sheetSelections, func(value string) {
+		c.selection = value
+		p.SetString("sheetSelection", value)
+	})
+	selectionCombo.SetSelected(p.StringWithFallback("sheetSelection", "even"))
+
+	headerLabel := widget.NewLabel("Header")
+	headerInput := widget.NewEntry()
+	headerInput.SetPlaceHolder("Optional")
+	headerInput.SetText(p.String("sheetHeader"))
+	c.header = headerInput.Text
+	headerInput.OnChanged = func(s string) {
+		c.header = s
+		p.SetString("sheetHeader", s)
+	}
+
+	formatLabel := widget.NewLabel("Format")
+	formatCombo := widget.NewSelect(sheetFormats, func(value string) {
+		c.format = value
+		p.SetString("sheetFormat", value)
+	})
+	formatCombo.SetSelected(p.StringWithFallback("sheetFormat", "png"))
+
+	exportButton := widget.NewButton("Export contact sheet", func() {
+		// Export from a copy so a running encode keeps its own state.
+		run := *e
+		go func() {
+			path, err := run.exportContactSheet(e.inputDirInput.Text, e.outputPath)
+			if err != nil {
+				e.encodeInfo.SetText(err.Error())
+				return
+			}
+			e.encodeInfo.SetText("wrote " + path)
+		}()
+	})
+
+	return widget.NewAccordionItem("Contact sheet", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), selectionLabel), nil, selectionCombo),
+		makeRow("Frame count", makeIntEntry("sheetCount", 24, &c.count)),
+		makeRow("Columns", makeIntEntry("sheetColumns", 6, &c.columns)),
+		makeRow("Thumbnail width", makeIntEntry("sheetThumbWidth", 320, &c.thumbWidth)),
+		makeRow("Spacing", makeIntEntry("sheetSpacing", 12, &c.spacing)),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), headerLabel), nil, headerInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), formatLabel), nil, formatCombo),
+		container.NewCenter(exportButton),
+	))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// targetAudioBitrate is set aside for background audio when encoding video
+// to a size.
+const targetAudioBitrate = 128000
+
+// maxFitAttempts limits how often an output is re-encoded to fit.
+const maxFitAttempts = 10
+
+// targetSize is the largest an output may be.
+type targetSize struct {
+	megabytes float64
+}
+
+func (t targetSize) active() bool {
+	return t.megabytes > 0
+}
+
+func (t targetSize) bytes() int64 {
+	return int64(t.megabytes * 1024 * 1024)
+}
+
+// videoBitrate returns the video bitrate that fits length seconds, and the
+// audio beside it, into the target. A little is kept back for the container.
+func (t targetSize) videoBitrate(length float64, audio bool) int {
+	bits := float64(t.bytes()) * 8 * 0.97
+	rate := bits / length
+	if audio {
+		rate -= targetAudioBitrate
+	}
+	return int(math.Max(rate, 50000))
+}
+
+// sizeFit holds the reductions made to fit an output to the target size.
+type sizeFit struct {
+	// shrink scales frames down, from 1.
+	shrink float64
+	// colors limits the GIF palette, if above 0.
+	colors int
+	// step keeps only every step-th frame, if above 1.
+	step int
+}
+
+// next returns a smaller fit for an output ratio times too large, or false if
+// nothing is left to reduce. Resolution goes first, then GIF colors, then
+// frames.
+func (f sizeFit) next(kind string, ratio float64) (sizeFit, bool) {
+	if f.shrink == 0 {
+		f.shrink = 1
+	}
+	switch {
+	case f.shrink > 0.25:
+		// Size roughly follows the area of the frames.
+		f.shrink = math.Max(0.25, f.shrink*math.Min(0.95, math.Sqrt(ratio)))
+	case kind == "gif" && (f.colors == 0 || f.colors > 32):
+		if f.colors == 0 {
+			f.colors = 256
+		}
+		f.colors /= 2
+	case f.step < 8:
+		if f.step < 1 {
+			f.step = 1
+		}
+		f.step *= 2
+	default:
+		return f, false
+	}
+	return f, true
+}
+
+// decimate keeps every step-th frame, each shown for the time of those
+// dropped after it.
+func (f sizeFit) decimate(files []string, delays []float64) ([]string, []float64) {
+	if f.step <= 1 {
+		return files, delays
+	}
+	var kept []string
+	var kd []float64
+	for i := range files {
+		if i%f.step == 0 {
+			kept = append(kept, files[i])
+			kd = append(kd, 0)
+		}
+		kd[len(kd)-1] += delays[i]
+	}
+	return kept, kd
+}
+
+func (f sizeFit) String() string {
+	var parts []string
+	if f.shrink > 0 && f.shrink < 1 {
+		parts = append(parts, fmt.Sprintf("scaled to %.0f%%", f.shrink*100))
+	}
+	if f.colors > 0 {
+		parts = append(parts, fmt.Sprintf("%d colors", f.colors))
+	}
+	if f.step > 1 {
+		parts = append(parts, fmt.Sprintf("every %d frames", f.step))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sizeAimer is implemented by backends that can encode some types straight to
+// the target size.
+type sizeAimer interface {
+	aimsAtSize(kind string) bool
+}
+
+// aimsAtSize reports whether b encodes kind at a bitrate that fits the target
+// size. Every other output is re-encoded smaller until it fits.
+func aimsAtSize(b encoderBackend, kind string) bool {
+	a, ok := b.(sizeAimer)
+	return ok && a.aimsAtSize(kind)
+}
+
+// fitSize re-encodes the kind output of job with b, smaller each time, until
+// it fits the target size.
+func (e *encoder) fitSize(b encoderBackend, job *encodeJob, kind string) error {
+	path := outputFile(job.Output, kind)
+	var fit sizeFit
+	for attempt := 0; ; attempt++ {
+		st, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if st.Size() <= e.target.bytes() {
+			if reduced := fit.String(); reduced != "" {
+				e.notes[kind] = strings.TrimPrefix(e.notes[kind]+", "+reduced, ", ")
+			}
+			return nil
+		}
+		var ok bool
+		fit, ok = fit.next(kind, float64(e.target.bytes())/float64(st.Size()))
+		if !ok || attempt == maxFitAttempts {
+			return fmt.Errorf("%s is still %.2f MB, above the target of %s MB", path, float64(st.Size())/1024/1024, strconv.FormatFloat(e.target.megabytes, 'f', -1, 64))
+		}
+		job.logf("%s is %.2f MB, retrying %s", path, float64(st.Size())/1024/1024, fit)
+		run := *e
+		run.fit = fit
+		run.geometry.shrink = fit.shrink
+		if err := run.encodeWith(b, job, []string{kind}); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *encoder) setupTarget() *widget.AccordionItem {
+	p := a.Preferences()
+	t := &e.target
+
+	sizeLabel := widget.NewLabel("Max size (MB)")
+	t.megabytes = p.Float("targetSize")
+	sizeInput := widget.NewEntry()
+	sizeInput.SetText(strconv.FormatFloat(t.megabytes, 'f', -1, 64))
+	sizeInput.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	sizeInput.OnChanged = func(s string) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 {
+			t.megabytes = f
+			p.SetFloat("targetSize", f)
+		}
+	}
+
+	return widget.NewAccordionItem("Target size", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), sizeLabel), nil, sizeInput),
+		widget.NewLabel("0 turns this off. ffmpeg video is encoded at a bitrate that fits\nwhere the codec allows, anything else is re-encoded with less\nresolution, colors or frames until it fits."),
+	))
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestAimsAtSize(t *testing.T) {
+	caps := aSettings.ffmpegCaps
+	defer func() { aSettings.ffmpegCaps = caps }()
+	aSettings.ffmpegCaps = toolCapabilities{
+		path:     "ffmpeg",
+		encoders: map[string]bool{"libx264": true, "prores_ks": true, "libwebp_anim": true, "mpeg4": true},
+		muxers:   map[string]bool{"mp4": true, "mov": true, "webp": true},
+	}
+	for _, tt := range []struct {
+		b    encoderBackend
+		kind string
+		want bool
+	}{
+		{ffmpegBackend{}, "mp4", true},
+		{ffmpegBackend{}, "prores", false},
+		{ffmpegBackend{}, "webp", false},
+		{ffmpegBackend{}, "hevc", false},
+		{integratedBackend{}, "avi", false},
+		{templateBackend{}, "mp4", false},
+	} {
+		if got := aimsAtSize(tt.b, tt.kind); got != tt.want {
+			t.Errorf("%s %s: got %v, want %v", tt.b.name(), tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestSizeFitNext(t *testing.T) {
+	var fit sizeFit
+	var steps []string
+	for ok := true; ok; {
+		if fit, ok = fit.next("gif", 0.1); ok {
+			steps = append(steps, fit.String())
+		}
+	}
+	want := []string{
+		"scaled to 32%",
+		"scaled to 25%",
+		"scaled to 25%, 128 colors",
+		"scaled to 25%, 64 colors",
+		"scaled to 25%, 32 colors",
+		"scaled to 25%, 32 colors, every 2 frames",
+		"scaled to 25%, 32 colors, every 4 frames",
+		"scaled to 25%, 32 colors, every 8 frames",
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %q, want %q", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d: got %q, want %q", i, steps[i], want[i])
+		}
+	}
+
+	if fit, _ := (sizeFit{}).next("avi", 0.5); fit.colors != 0 {
+		t.Errorf("avi reduced to %d colors", fit.colors)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+var templateTools = []string{"ffmpeg", "convert", "magick"}
+
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// argTemplate is a user defined command, offered as an output type named
+// after it. Args may hold these placeholders:
+//
+//	{input}    the directory holding the frames
+//	{frames}   every frame, as separate arguments
+//	{filelist} an ffmpeg concat script showing each frame for its time
+//	{fps}      the frame rate, on average if it varies
+//	{output}   the file to write
+type argTemplate struct {
+	Name string
+	// Tool is ffmpeg, convert or magick, using the configured path, or else
+	// the path of any other program.
+	Tool string
+	Args string
+	Ext  string
+}
+
+// templateBackend runs the saved argument templates. It is kept out of the
+// registry, as its types are offered in addition to those of the selected
+// backend.
+type templateBackend struct{}
+
+var argTemplates []argTemplate
+
+func loadTemplates() {
+	argTemplates = nil
+	if s := a.Preferences().String("argTemplates"); s != "" {
+		if err := json.Unmarshal([]byte(s), &argTemplates); err != nil {
+			log.Println("Error loading argument templates", err)
+		}
+	}
+}
+
+func saveTemplates() {
+	b, err := json.Marshal(argTemplates)
+	if err != nil {
+		log.Println("Error saving argument templates", err)
+		return
+	}
+	a.Preferences().SetString("argTemplates", string(b))
+}
+
+func findTemplate(name string) *argTemplate {
+	for i := range argTemplates {
+		if argTemplates[i].Name == name {
+			return &argTemplates[i]
+		}
+	}
+	return nil
+}
+
+// builtinKind reports whether a backend already writes kind.
+func builtinKind(kind string) bool {
+	if findFFMPEGFormat(kind) != nil || hasKind(integratedBackend{}.formats(), kind) {
+		return true
+	}
+	for _, f := range magickFormats {
+		if f.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (t argTemplate) validate() error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("names may only hold lowercase letters, digits and dashes")
+	} else if builtinKind(t.Name) {
+		return fmt.Errorf("%s is already an output type", t.Name)
+	} else if t.Tool == "" {
+		return fmt.Errorf("no tool given")
+	} else if !templateNamePattern.MatchString(t.Ext) {
+		return fmt.Errorf("invalid extension %q", t.Ext)
+	}
+	_, err := splitArgs(t.Args)
+	return err
+}
+
+// toolPath resolves the tool to the configured path of ffmpeg or
+// ImageMagick, or leaves it as given.
+func (t argTemplate) toolPath() string {
+	switch t.Tool {
+	case "ffmpeg":
+		return aSettings.getFFMPEGPath()
+	case "convert":
+		return aSettings.getConvertPath()
+	case "magick":
+		return aSettings.getMagickPath()
+	}
+	return t.Tool
+}
+
+// splitArgs splits s on whitespace, keeping text in single or double quotes
+// together.
+func splitArgs(s string) (args []string, err error) {
+	var b strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, b.String())
+				b.Reset()
+				inArg = false
+			}
+		default:
+			b.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+func (templateBackend) name() string {
+	return "template"
+}
+
+func (templateBackend) available() bool {
+	return len(argTemplates) > 0
+}
+
+func (templateBackend) formats() (types []string) {
+	for _, t := range argTemplates {
+		types = append(types, t.Name)
+	}
+	return
+}
+
+// Templates are handed frames as files, and do not know how to crop, scale or
+// mix formats.
+func (templateBackend) mixedFormats() bool {
+	return false
+}
+
+func (templateBackend) layouts() bool {
+	return false
+}
+
+func (templateBackend) encode(e *encoder, job *encodeJob, kinds []string, f *encodeFrames) error {
+	dir, _ := filepath.Abs(f.dir)
+	fps := f.fps
+	if !f.uniform {
+		fps = float64(len(f.delays)) / totalDuration(f.delays)
+	}
+	for _, kind := range kinds {
+		t := findTemplate(kind)
+		if t == nil {
+			return fmt.Errorf("no template named %s", kind)
+		}
+		args, err := splitArgs(t.Args)
+		if err != nil {
+			return err
+		}
+		var expanded []string
+		for _, arg := range args {
+			if arg == "{frames}" {
+				expanded = append(expanded, f.files...)
+				continue
+			}
+			if strings.Contains(arg, "{filelist}") {
+				list, remove, err := e.concatList(f)
+				if err != nil {
+					return err
+				}
+				defer remove()
+				arg = strings.ReplaceAll(arg, "{filelist}", list)
+			}
+			arg = strings.NewReplacer(
+				"{input}", dir,
+				"{fps}", strconv.FormatFloat(fps, 'f', -1, 64),
+				"{output}", outputFile(job.Output, kind),
+			).Replace(arg)
+			expanded = append(expanded, arg)
+		}
+		if err := e.runCmd(job, t.toolPath(), f.dir, expanded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) setupTemplates() *widget.AccordionItem {
+	nameInput := widget.NewEntry()
+	nameInput.SetPlaceHolder("webm-hq")
+	toolInput := widget.NewSelectEntry(templateTools)
+	toolInput.SetText("ffmpeg")
+	extInput := widget.NewEntry()
+	extInput.SetPlaceHolder("webm")
+	argsInput := widget.NewMultiLineEntry()
+	argsInput.SetPlaceHolder("-y -f concat -safe 0 -i {filelist} -c:v libvpx-vp9 -crf 30 -b:v 0 {output}")
+	argsInput.Wrapping = fyne.TextWrapWord
+
+	var templateCombo *widget.Select
+	names := func() (names []string) {
+		for _, t := range argTemplates {
+			names = append(names, t.Name)
+		}
+		return
+	}
+	changed := func() {
+		saveTemplates()
+		templateCombo.Options = names()
+		templateCombo.Refresh()
+		e.refreshTypes()
+	}
+	templateCombo = widget.NewSelect(names(), func(value string) {
+		if t := findTemplate(value); t != nil {
+			nameInput.SetText(t.Name)
+			toolInput.SetText(t.Tool)
+			extInput.SetText(t.Ext)
+			argsInput.SetText(t.Args)
+		}
+	})
+	templateCombo.PlaceHolder = "New template"
+	var templatesItem *widget.AccordionItem
+	e.editTemplate = func(t argTemplate) {
+		templateCombo.ClearSelected()
+		nameInput.SetText(t.Name)
+		toolInput.SetText(t.Tool)
+		extInput.SetText(t.Ext)
+		argsInput.SetText(t.Args)
+		for i, item := range e.options.Items {
+			if item == templatesItem {
+				e.options.Open(i)
+			}
+		}
+	}
+
+	saveButton := widget.NewButton("Save", func() {
+		t := argTemplate{
+			Name: strings.TrimSpace(nameInput.Text),
+			Tool: strings.TrimSpace(toolInput.Text),
+			Args: argsInput.Text,
+			Ext:  strings.TrimPrefix(strings.TrimSpace(extInput.Text), "."),
+		}
+		if err := t.validate(); err != nil {
+			dialog.ShowError(err, window)
+			return
+		}
+		if existing := findTemplate(t.Name); existing != nil {
+			*existing = t
+		} else {
+			argTemplates = append(argTemplates, t)
+		}
+		changed()
+		templateCombo.SetSelected(t.Name)
+	})
+	deleteButton := widget.NewButton("Delete", func() {
+		for i, t := range argTemplates {
+			if t.Name == templateCombo.Selected {
+				argTemplates = append(argTemplates[:i], argTemplates[i+1:]...)
+				break
+			}
+		}
+		changed()
+		templateCombo.ClearSelected()
+	})
+
+	templatesItem = widget.NewAccordionItem("Custom templates", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel("Template")), nil, templateCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel("Name")), nil, nameInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel("Tool")), nil, toolInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel("Extension")), nil, extInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), widget.NewLabel("Arguments")), nil, argsInput),
+		widget.NewLabel("Placeholders: {input} {frames} {filelist} {fps} {output}"),
+		container.NewCenter(container.NewHBox(saveButton, deleteButton)),
+	))
+	return templatesItem
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var stampModes = []string{"off", "timestamp", "elapsed"}
+var stampPositions = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// caption is text drawn over a range of frames, by frame number.
+type caption struct {
+	from, to int
+	text     string
+}
+
+// frameText describes the title card, end card, captions and timestamp drawn
+// into the encoded frames.
+type frameText struct {
+	title     string
+	titleHold float64
+	end       string
+	endHold   float64
+	size      float64
+	fg, bg    color.RGBA
+	captions  []caption
+
+	stampMode     string
+	stampFormat   string
+	stampPosition string
+	stampZone     *time.Location
+}
+
+// textFrame identifies a frame for the text drawn over it.
+type textFrame struct {
+	number   int
+	captured time.Time
+}
+
+var textFont struct {
+	once sync.Once
+	font *opentype.Font
+	err  error
+}
+
+// textFace returns the bundled Go font at size pixels.
+func textFace(size float64) (font.Face, error) {
+	textFont.once.Do(func() {
+		textFont.font, textFont.err = opentype.Parse(goregular.TTF)
+	})
+	if textFont.err != nil {
+		return nil, textFont.err
+	}
+	return opentype.NewFace(textFont.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// parseCaptions reads one caption per line, such as "1-120 Installing deps".
+func parseCaptions(s string) (captions []caption, err error) {
+	for n, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		span, text, _ := strings.Cut(line, " ")
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid range %q", n+1, span)
+		}
+		var c caption
+		if c.from, err = strconv.Atoi(from); err == nil {
+			c.to, err = strconv.Atoi(to)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range %q", n+1, span)
+		}
+		c.text = strings.TrimSpace(text)
+		captions = append(captions, c)
+	}
+	return captions, nil
+}
+
+func (t frameText) hasTitle() bool {
+	return strings.TrimSpace(t.title) != "" && t.titleHold > 0
+}
+
+func (t frameText) hasEnd() bool {
+	return strings.TrimSpace(t.end) != "" && t.endHold > 0
+}
+
+func (t frameText) hasStamp() bool {
+	return t.stampMode == "timestamp" || t.stampMode == "elapsed"
+}
+
+func (t frameText) active() bool {
+	return t.hasTitle() || t.hasEnd() || len(t.captions) > 0 || t.hasStamp()
+}
+
+// stamp formats the capture time of a frame, or the time since start.
+func (t frameText) stamp(captured, start time.Time) string {
+	if t.stampMode == "elapsed" {
+		return formatElapsed(captured.Sub(start), t.stampFormat)
+	}
+	zone := t.stampZone
+	if zone == nil {
+		zone = time.Local
+	}
+	return captured.In(zone).Format(t.stampFormat)
+}
+
+// formatElapsed formats d with the hours, minutes and seconds of a time
+// layout, 15, 04 and 05. Hours keep counting past a day.
+func formatElapsed(d time.Duration, layout string) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Truncate(time.Second)
+	return strings.NewReplacer(
+		"15", fmt.Sprintf("%02d", int(d/time.Hour)),
+		"04", fmt.Sprintf("%02d", int(d/time.Minute)%60),
+		"05", fmt.Sprintf("%02d", int(d/time.Second)%60),
+	).Replace(layout)
+}
+
+// wrap adds the cards to delays and returns a loader that renders them and
+// draws captions and timestamps over the frames from load. Elapsed time is
+// counted from start.
+func (t frameText) wrap(size image.Point, frames []textFrame, start time.Time, delays []float64, load frameLoader) ([]float64, frameLoader) {
+	var out []float64
+	if t.hasTitle() {
+		out = append(out, t.titleHold)
+	}
+	offset := len(out)
+	out = append(out, delays...)
+	if t.hasEnd() {
+		out = append(out, t.endHold)
+	}
+	return out, func(i int) (*image.RGBA, error) {
+		if i < offset {
+			return t.card(size, t.title)
+		}
+		if i-offset >= len(frames) {
+			return t.card(size, t.end)
+		}
+		m, err := load(i - offset)
+		if err != nil {
+			return nil, err
+		}
+		f := frames[i-offset]
+		for _, c := range t.captions {
+			if f.number >= c.from && f.number <= c.to && c.text != "" {
+				if err := t.drawLabel(m, c.text, "bottom"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if t.hasStamp() {
+			if err := t.drawLabel(m, t.stamp(f.captured, start), t.stampPosition); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+}
+
+// card renders text centered on a plain background.
+func (t frameText) card(size image.Point, text string) (*image.RGBA, error) {
+	m := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(m, m.Rect, image.NewUniform(t.bg), image.Point{}, draw.Src)
+	face, err := textFace(t.size)
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	height := face.Metrics().Height
+	y := fixed.I(size.Y)/2 - height*fixed.Int26_6(len(lines))/2 + face.Metrics().Ascent
+	d := font.Drawer{Dst: m, Src: image.NewUniform(t.fg), Face: face}
+	for _, line := range lines {
+		d.Dot = fixed.Point26_6{X: (fixed.I(size.X) - d.MeasureString(line)) / 2, Y: y}
+		d.DrawString(line)
+		y += height
+	}
+	return m, nil
+}
+
+// drawLabel draws text on a translucent box, centered at the bottom of m or
+// in one of its corners.
+func (t frameText) drawLabel(m *image.RGBA, text string, position string) error {
+	face, err := textFace(t.size)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	d := font.Drawer{Dst: m, Src: image.NewUniform(t.fg), Face: face}
+	metrics := face.Metrics()
+	pad := int(t.size / 3)
+	w := d.MeasureString(text).Ceil()
+	h := metrics.Height.Ceil()
+	box := image.Rect(0, 0, w+pad*2, h+pad*2)
+	x, y := (m.Rect.Dx()-box.Dx())/2, m.Rect.Dy()-box.Dy()-pad
+	if strings.HasPrefix(position, "top") {
+		y = pad
+	}
+	if strings.HasSuffix(position, "left") {
+		x = pad
+	} else if strings.HasSuffix(position, "right") {
+		x = m.Rect.Dx() - box.Dx() - pad
+	}
+	box = box.Add(image.Pt(x, y))
+	bg := color.NRGBA{t.bg.R, t.bg.G, t.bg.B, 192}
+	draw.Draw(m, box, image.NewUniform(bg), image.Point{}, draw.Over)
+	d.Dot = fixed.P(box.Min.X+pad, box.Min.Y+pad).Add(fixed.Point26_6{Y: metrics.Ascent})
+	d.DrawString(text)
+	return nil
+}
+
+func (e *encoder) setupText() *widget.AccordionItem {
+	p := a.Preferences()
+	t := &e.text
+
+	titleLabel := widget.NewLabel("Title card")
+	titleInput := widget.NewMultiLineEntry()
+	titleInput.SetPlaceHolder("Title")
+	titleInput.SetText(p.String("titleText"))
+	t.title = titleInput.Text
+	titleInput.OnChanged = func(s string) {
+		t.title = s
+		p.SetString("titleText", s)
+	}
+	titleHoldLabel := widget.NewLabel("Title seconds")
+	titleHold := makeFloatEntry("titleHold", 3, &t.titleHold)
+
+	endLabel := widget.NewLabel("End card")
+	endInput := widget.NewMultiLineEntry()
+	endInput.SetPlaceHolder("The end")
+	endInput.SetText(p.String("endText"))
+	t.end = endInput.Text
+	endInput.OnChanged = func(s string) {
+		t.end = s
+		p.SetString("endText", s)
+	}
+	endHoldLabel := widget.NewLabel("End seconds")
+	endHold := makeFloatEntry("endHold", 3, &t.endHold)
+
+	sizeLabel := widget.NewLabel("Font size")
+	size := makeFloatEntry("textSize", 32, &t.size)
+
+	colorsLabel := widget.NewLabel("Text, background")
+	t.fg, _ = parseHexColor(p.StringWithFallback("textColor", "#ffffff"))
+	t.bg, _ = parseHexColor(p.StringWithFallback("textBackground", "#000000"))
+	fgColor := makeHexColorEntry(formatHexColor(t.fg))
+	fgColor.OnChanged = func(s string) {
+		if c, err := parseHexColor(s); err == nil {
+			t.fg = c
+			p.SetString("textColor", s)
+		}
+	}
+	bgColor := makeHexColorEntry(formatHexColor(t.bg))
+	bgColor.OnChanged = func(s string) {
+		if c, err := parseHexColor(s); err == nil {
+			t.bg = c
+			p.SetString("textBackground", s)
+		}
+	}
+
+	captionsLabel := widget.NewLabel("Captions")
+	captionsInput := widget.NewMultiLineEntry()
+	captionsInput.SetPlaceHolder("1-120 Installing dependencies")
+	captionsInput.SetText(p.String("captions"))
+	captionsInput.Validator = func(s string) error {
+		_, err := parseCaptions(s)
+		return err
+	}
+	t.captions, _ = parseCaptions(captionsInput.Text)
+	captionsInput.OnChanged = func(s string) {
+		if captions, err := parseCaptions(s); err == nil {
+			t.captions = captions
+			p.SetString("captions", s)
+		}
+	}
+
+	stampLabel := widget.NewLabel("Timestamp")
+	stampFormatInput := widget.NewEntry()
+	stampFormatInput.SetPlaceHolder("2006-01-02 15:04:05")
+	stampFormatInput.SetText(p.StringWithFallback("stampFormat", "2006-01-02 15:04:05"))
+	t.stampFormat = stampFormatInput.Text
+	stampFormatInput.OnChanged = func(s string) {
+		t.stampFormat = s
+		p.SetString("stampFormat", s)
+	}
+	stampModeCombo := widget.NewSelect(stampModes, func(value string) {
+		t.stampMode = value
+		p.SetString("stampMode", value)
+		// Elapsed time only fills in hours, minutes and seconds, so the date
+		// in the default layout would be left as it is.
+		if value == "elapsed" && stampFormatInput.Text == "2006-01-02 15:04:05" {
+			stampFormatInput.SetText("15:04:05")
+		}
+	})
+	stampModeCombo.SetSelected(p.StringWithFallback("stampMode", "off"))
+	stampFormatLabel := widget.NewLabel("Timestamp format")
+
+	stampPositionLabel := widget.NewLabel("Timestamp position")
+	stampPositionCombo := widget.NewSelect(stampPositions, func(value string) {
+		t.stampPosition = value
+		p.SetString("stampPosition", value)
+	})
+	stampPositionCombo.SetSelected(p.StringWithFallback("stampPosition", "top-right"))
+
+	stampZoneLabel := widget.NewLabel("Timezone")
+	stampZoneInput := widget.NewEntry()
+	stampZoneInput.SetPlaceHolder("Local, UTC, Europe/Berlin")
+	stampZoneInput.SetText(p.StringWithFallback("stampZone", "Local"))
+	stampZoneInput.Validator = func(s string) error {
+		_, err := time.LoadLocation(s)
+		return err
+	}
+	t.stampZone, _ = time.LoadLocation(stampZoneInput.Text)
+	stampZoneInput.OnChanged = func(s string) {
+		if zone, err := time.LoadLocation(s); err == nil {
+			t.stampZone = zone
+			p.SetString("stampZone", s)
+		}
+	}
+
+	return widget.NewAccordionItem("Text", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), titleLabel), nil, titleInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), titleHoldLabel), nil, titleHold),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), endLabel), nil, endInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), endHoldLabel), nil, endHold),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), sizeLabel), nil, size),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), colorsLabel), nil,
+			container.NewAdaptiveGrid(2, fgColor, bgColor),
+		),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), captionsLabel), nil, captionsInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), stampLabel), nil, stampModeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), stampFormatLabel), nil, stampFormatInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), stampPositionLabel), nil, stampPositionCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), stampZoneLabel), nil, stampZoneInput),
+	))
+}
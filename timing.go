@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+var timingModes = []string{"fps", "duration"}
+
+// speedRamp plays a range of frames, given by frame number or by time of
+// day, at a multiple of the normal rate.
+type speedRamp struct {
+	from, to   int
+	start, end time.Duration
+	clock      bool
+	speed      float64
+}
+
+// frameTiming decides how long each frame is shown.
+type frameTiming struct {
+	mode     string
+	duration time.Duration
+	maxFPS   float64
+	ramps    []speedRamp
+}
+
+// parseSpeedRamps reads one ramp per line, such as "1-120 4x" or
+// "14:05-14:20 0.5x". Later lines override earlier ones.
+func parseSpeedRamps(s string) (ramps []speedRamp, err error) {
+	for n, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a range and a speed", n+1)
+		}
+		var r speedRamp
+		r.speed, err = strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(fields[1]), "x"), 64)
+		if err != nil || r.speed <= 0 {
+			return nil, fmt.Errorf("line %d: invalid speed %q", n+1, fields[1])
+		}
+		from, to, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid range %q", n+1, fields[0])
+		}
+		if strings.Contains(from, ":") {
+			r.clock = true
+			if r.start, err = parseClock(from); err == nil {
+				r.end, err = parseClock(to)
+			}
+		} else if r.from, err = strconv.Atoi(from); err == nil {
+			r.to, err = strconv.Atoi(to)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid range %q", n+1, fields[0])
+		}
+		ramps = append(ramps, r)
+	}
+	return ramps, nil
+}
+
+// parseClock parses a time of day as an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04:05", s)
+	if err != nil {
+		if t, err = time.Parse("15:04", s); err != nil {
+			return 0, err
+		}
+	}
+	return clockOf(t), nil
+}
+
+func clockOf(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// parseLength parses a duration such as "90", "5m" or "1m30s".
+func parseLength(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// matches reports whether the ramp covers the i-th frame, captured at t.
+func (r speedRamp) matches(i int, t time.Time) bool {
+	if !r.clock {
+		return i+1 >= r.from && i+1 <= r.to
+	}
+	c := clockOf(t)
+	if r.start <= r.end {
+		return c >= r.start && c <= r.end
+	}
+	// The range wraps past midnight.
+	return c >= r.start || c <= r.end
+}
+
+// schedule returns the frames to encode and how long each is shown, in
+// seconds. When fitting a duration, frames that would be shown faster than
+// maxFPS are dropped, with their time given to the frame before them.
+func (t frameTiming) schedule(inpath string, files []string, fps float64) (kept []string, delays []float64, err error) {
+	clock := false
+	for _, r := range t.ramps {
+		clock = clock || r.clock
+	}
+	speeds := make([]float64, len(files))
+	var weight float64
+	for i, f := range files {
+		speeds[i] = 1
+		var captured time.Time
+		if clock {
+			captured = frameTime(inpath, f)
+		}
+		for _, r := range t.ramps {
+			if r.matches(i, captured) {
+				speeds[i] = r.speed
+			}
+		}
+		weight += 1 / speeds[i]
+	}
+
+	var base float64
+	if t.mode == "duration" {
+		if t.duration <= 0 {
+			return nil, nil, fmt.Errorf("invalid duration: %s", t.duration)
+		}
+		base = t.duration.Seconds() / weight
+	} else {
+		if fps <= 0 {
+			return nil, nil, fmt.Errorf("invalid fps: %s", strconv.FormatFloat(fps, 'f', -1, 64))
+		}
+		base = 1 / fps
+	}
+
+	var minDelay float64
+	if t.mode == "duration" && t.maxFPS > 0 {
+		minDelay = 1 / t.maxFPS
+	}
+	for i, f := range files {
+		d := base / speeds[i]
+		if n := len(delays); n > 0 && delays[n-1] < minDelay-1e-9 {
+			delays[n-1] += d
+			continue
+		}
+		kept = append(kept, f)
+		delays = append(delays, d)
+	}
+	return kept, delays, nil
+}
+
+// uniformRate returns the frame rate if every frame but the last is shown for
+// the same time. The last may be cut short by dropped frames, but not held
+// for longer, as a constant rate would lose the hold.
+func uniformRate(delays []float64) (float64, bool) {
+	for _, d := range delays[:len(delays)-1] {
+		if math.Abs(d-delays[0]) > 1e-9 {
+			return 0, false
+		}
+	}
+	if delays[len(delays)-1] > delays[0]+1e-9 {
+		return 0, false
+	}
+	return math.Round(1e6/delays[0]) / 1e6, true
+}
+
+// centiseconds converts delays to the hundredths of a second GIF and
+// ImageMagick use, carrying rounding errors so the total stays accurate.
+func centiseconds(delays []float64) []int {
+	centis := make([]int, len(delays))
+	var carry float64
+	for i, d := range delays {
+		d = d*100 + carry
+		centis[i] = int(math.Round(d))
+		carry = d - float64(centis[i])
+	}
+	return centis
+}
+
+// writeConcatList writes an ffmpeg concat demuxer script that shows each
+// frame for its delay, returning the script's path.
+func writeConcatList(inpath string, files []string, delays []float64) (string, error) {
+	f, err := os.CreateTemp("", "gosh-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	quote := func(name string) string {
+		p, _ := filepath.Abs(filepath.Join(inpath, name))
+		return "'" + strings.ReplaceAll(p, "'", "'\\''") + "'"
+	}
+	for i, name := range files {
+		fmt.Fprintf(&b, "file %s\nduration %f\n", quote(name), delays[i])
+	}
+	// The last duration only applies if the file is listed again.
+	fmt.Fprintf(&b, "file %s\n", quote(files[len(files)-1]))
+	if _, err := f.WriteString(b.String()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// concatList writes the concat script for f, returning its path and a
+// function removing it. Previews show {filelist} instead, as no script is
+// written for them and a temporary name would change every time.
+func (e *encoder) concatList(f *encodeFrames) (string, func(), error) {
+	if e.commands != nil {
+		return "{filelist}", func() {}, nil
+	}
+	list, err := writeConcatList(f.dir, f.files, f.delays)
+	if err != nil {
+		return "", nil, err
+	}
+	return list, func() { os.Remove(list) }, nil
+}
+
+func totalDuration(delays []float64) (total float64) {
+	for _, d := range delays {
+		total += d
+	}
+	return
+}
+
+func (e *encoder) setupTiming() *widget.AccordionItem {
+	p := a.Preferences()
+	t := &e.timing
+
+	durationLabel := widget.NewLabel("Duration")
+	durationInput := widget.NewEntry()
+	durationInput.SetPlaceHolder("30s, 5m")
+	durationInput.SetText(p.StringWithFallback("targetDuration", "30s"))
+	durationInput.Validator = func(s string) error {
+		_, err := parseLength(s)
+		return err
+	}
+	t.duration, _ = parseLength(durationInput.Text)
+	durationInput.OnChanged = func(s string) {
+		if d, err := parseLength(s); err == nil {
+			t.duration = d
+			p.SetString("targetDuration", s)
+		}
+	}
+
+	maxFPSLabel := widget.NewLabel("Max FPS")
+	t.maxFPS = p.FloatWithFallback("maxFPS", 60)
+	maxFPSInput := widget.NewEntry()
+	maxFPSInput.SetText(strconv.FormatFloat(t.maxFPS, 'f', -1, 64))
+	maxFPSInput.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	maxFPSInput.OnChanged = func(s string) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			t.maxFPS = f
+			p.SetFloat("maxFPS", f)
+		}
+	}
+
+	modeLabel := widget.NewLabel("Timing")
+	modeCombo := widget.NewSelect(timingModes, func(value string) {
+		t.mode = value
+		p.SetString("timingMode", value)
+		if value == "duration" {
+			durationInput.Enable()
+			maxFPSInput.Enable()
+		} else {
+			durationInput.Disable()
+			maxFPSInput.Disable()
+		}
+	})
+	modeCombo.SetSelected(p.StringWithFallback("timingMode", "fps"))
+
+	rampsLabel := widget.NewLabel("Speed ramps")
+	rampsInput := widget.NewMultiLineEntry()
+	rampsInput.SetPlaceHolder("1-120 4x\n14:05-14:20 0.5x")
+	rampsInput.SetText(p.String("speedRamps"))
+	rampsInput.Validator = func(s string) error {
+		_, err := parseSpeedRamps(s)
+		return err
+	}
+	t.ramps, _ = parseSpeedRamps(rampsInput.Text)
+	rampsInput.OnChanged = func(s string) {
+		if ramps, err := parseSpeedRamps(s); err == nil {
+			t.ramps = ramps
+			p.SetString("speedRamps", s)
+		}
+	}
+
+	result := widget.NewLabel("")
+	calculateButton := widget.NewButton("Calculate", func() {
+		result.SetText(e.calculateTiming())
+	})
+
+	return widget.NewAccordionItem("Timing", container.NewVBox(
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), modeLabel), nil, modeCombo),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), durationLabel), nil, durationInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), maxFPSLabel), nil, maxFPSInput),
+		container.NewBorder(nil, nil, container.NewGridWrap(fyne.NewSize(150, 0), rampsLabel), nil, rampsInput),
+		container.NewCenter(calculateButton),
+		result,
+	))
+}
+
+// calculateTiming describes the capture span and the resulting encode.
+func (e *encoder) calculateTiming() string {
+	inpath := e.inputDirInput.Text
+	files, err := e.listFrames(inpath)
+	if err != nil {
+		return err.Error()
+	}
+	fps, _ := strconv.ParseFloat(e.fpsInput.Text, 64)
+	kept, delays, err := e.timing.schedule(inpath, files, fps)
+	if err != nil {
+		return err.Error()
+	}
+	dropped := len(files) - len(kept)
+	kept, delays = e.loop.apply(kept, delays)
+	span := frameTime(inpath, files[len(files)-1]).Sub(frameTime(inpath, files[0]))
+	total := totalDuration(delays)
+	lines := []string{
+		fmt.Sprintf("Captured: %d frames over %s", len(files), span.Round(time.Second)),
+		fmt.Sprintf("Encoded: %d frames, %s (%.2f fps average)", len(kept), time.Duration(total*float64(time.Second)).Round(time.Millisecond), float64(len(kept))/total),
+	}
+	if dropped > 0 {
+		lines = append(lines, fmt.Sprintf("Dropped: %d frames above %s fps", dropped, strconv.FormatFloat(e.timing.maxFPS, 'f', -1, 64)))
+	}
+	var cards float64
+	if e.text.hasTitle() {
+		cards += e.text.titleHold
+	}
+	if e.text.hasEnd() {
+		cards += e.text.endHold
+	}
+	if cards > 0 {
+		lines = append(lines, fmt.Sprintf("With cards: %s", time.Duration((total+cards)*float64(time.Second)).Round(time.Millisecond)))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSpeedRamps(t *testing.T) {
+	ramps, err := parseSpeedRamps("1-120 4x\n\n  14:05-14:20 0.5X \n23:30:15-00:30 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []speedRamp{
+		{from: 1, to: 120, speed: 4},
+		{start: 14*time.Hour + 5*time.Minute, end: 14*time.Hour + 20*time.Minute, clock: true, speed: 0.5},
+		{start: 23*time.Hour + 30*time.Minute + 15*time.Second, end: 30 * time.Minute, clock: true, speed: 2},
+	}
+	if !reflect.DeepEqual(ramps, want) {
+		t.Errorf("got %+v, want %+v", ramps, want)
+	}
+
+	for _, bad := range []string{"1-120", "1-120 0x", "1-120 fast", "120 2x", "a-b 2x", "14:05-2pm 2x", "1-2 3x 4"} {
+		if _, err := parseSpeedRamps(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestSpeedRampMatchesPastMidnight(t *testing.T) {
+	r := speedRamp{start: 23 * time.Hour, end: time.Hour, clock: true, speed: 2}
+	for clock, want := range map[string]bool{"22:59": false, "23:30": true, "00:30": true, "01:30": false} {
+		at, _ := time.Parse("15:04", clock)
+		if got := r.matches(0, at); got != want {
+			t.Errorf("%s: got %v, want %v", clock, got, want)
+		}
+	}
+}
+
+// clockFrames names n frames captured every interval from the time of day
+// start, as the recorder does.
+func clockFrames(start string, interval time.Duration, n int) []string {
+	at, _ := time.ParseInLocation("15:04", start, time.Local)
+	at = time.Date(2024, 3, 1, at.Hour(), at.Minute(), 0, 0, time.Local)
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("%d.png", at.Add(time.Duration(i)*interval).UnixMilli())
+	}
+	return files
+}
+
+func approxEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSchedule(t *testing.T) {
+	files := []string{"1.png", "2.png", "3.png", "4.png", "5.png", "6.png"}
+	for _, tt := range []struct {
+		name   string
+		timing frameTiming
+		files  []string
+		fps    float64
+		kept   int
+		delays []float64
+	}{
+		{"fps", frameTiming{mode: "fps"}, files, 10, 6, []float64{.1, .1, .1, .1, .1, .1}},
+		{"fps ignores max fps", frameTiming{mode: "fps", maxFPS: 5}, files, 10, 6, []float64{.1, .1, .1, .1, .1, .1}},
+		{"duration", frameTiming{mode: "duration", duration: 3 * time.Second}, files, 0, 6, []float64{.5, .5, .5, .5, .5, .5}},
+		{"duration drops above max fps", frameTiming{mode: "duration", duration: 300 * time.Millisecond, maxFPS: 10}, files, 0, 3, []float64{.1, .1, .1}},
+		{"frame ramp", frameTiming{mode: "fps", ramps: []speedRamp{{from: 2, to: 3, speed: 2}}}, files, 10, 6, []float64{.1, .05, .05, .1, .1, .1}},
+		{"later ramps win", frameTiming{mode: "fps", ramps: []speedRamp{{from: 1, to: 6, speed: 2}, {from: 6, to: 6, speed: 0.5}}}, files, 10, 6, []float64{.05, .05, .05, .05, .05, .2}},
+		{"duration with ramp", frameTiming{mode: "duration", duration: 4 * time.Second, ramps: []speedRamp{{from: 1, to: 4, speed: 4}}}, files, 0, 6, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3, 1.0 / 3, 4.0 / 3, 4.0 / 3}},
+		{"clock ramp", frameTiming{mode: "fps", ramps: []speedRamp{{start: 14*time.Hour + time.Minute, end: 14*time.Hour + 2*time.Minute, clock: true, speed: 4}}}, clockFrames("14:00", 30*time.Second, 6), 4, 6, []float64{.25, .25, 1.0 / 16, 1.0 / 16, 1.0 / 16, .25}},
+	} {
+		kept, delays, err := tt.timing.schedule(t.TempDir(), tt.files, tt.fps)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(kept) != tt.kept || !approxEqual(delays, tt.delays) {
+			t.Errorf("%s: kept %d frames shown %v, want %d shown %v", tt.name, len(kept), delays, tt.kept, tt.delays)
+		}
+		if tt.timing.mode == "duration" && math.Abs(totalDuration(delays)-tt.timing.duration.Seconds()) > 1e-9 {
+			t.Errorf("%s: lasts %v, want %v", tt.name, totalDuration(delays), tt.timing.duration.Seconds())
+		}
+	}
+
+	for _, bad := range []frameTiming{{mode: "fps"}, {mode: "duration"}} {
+		if _, _, err := bad.schedule("", files, 0); err == nil {
+			t.Errorf("%+v scheduled", bad)
+		}
+	}
+}
+
+func TestUniformRate(t *testing.T) {
+	for _, tt := range []struct {
+		delays  []float64
+		rate    float64
+		uniform bool
+	}{
+		{[]float64{.1}, 10, true},
+		{[]float64{.1, .1, .1}, 10, true},
+		{[]float64{.1, .1, .05}, 10, true},
+		{[]float64{.1, .1, 2.1}, 0, false},
+		{[]float64{.1, .2, .1}, 0, false},
+		{[]float64{1.0 / 30, 1.0 / 30}, 30, true},
+	} {
+		rate, uniform := uniformRate(tt.delays)
+		if uniform != tt.uniform || math.Abs(rate-tt.rate) > 1e-6 {
+			t.Errorf("%v: got %v %v, want %v %v", tt.delays, rate, uniform, tt.rate, tt.uniform)
+		}
+	}
+}
+
+func TestCentiseconds(t *testing.T) {
+	for _, tt := range []struct {
+		delays []float64
+		want   []int
+	}{
+		{[]float64{.1, .25, 1}, []int{10, 25, 100}},
+		// A third of a second can't be shown exactly, but the total keeps
+		// up.
+		{[]float64{1.0 / 3, 1.0 / 3, 1.0 / 3}, []int{33, 34, 33}},
+		{[]float64{.004, .004, .004, .004}, []int{0, 1, 0, 1}},
+	} {
+		if got := centiseconds(tt.delays); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.delays, got, tt.want)
+		}
+	}
+}